@@ -0,0 +1,110 @@
+variable "result_bucket" {
+  description = "Bucket the crawler writes results and archived pages to (RESULT_BUCKET)"
+  type        = string
+  default     = "skale-crawling-manager"
+}
+
+variable "raw_archive_enabled" {
+  description = "Whether the crawler archives result pages (RAW_ARCHIVE_ENABLED); enables the raw/ expiry rule"
+  type        = bool
+  default     = false
+}
+
+variable "raw_archive_retention_days" {
+  description = "Days archived result pages under raw/ are kept"
+  type        = number
+  default     = 30
+}
+
+variable "result_bucket_lifecycle_rules" {
+  description = "The bucket's other lifecycle rules, copied from aws s3api get-bucket-lifecycle-configuration; kept next to raw-html-archive"
+  type = list(object({
+    id                                     = string
+    status                                 = optional(string, "Enabled")
+    prefix                                 = optional(string, "")
+    expiration_days                        = optional(number)
+    noncurrent_version_expiration_days     = optional(number)
+    abort_incomplete_multipart_upload_days = optional(number)
+    transitions = optional(list(object({
+      days          = number
+      storage_class = string
+    })), [])
+  }))
+  default = []
+
+  validation {
+    condition     = !contains([for rule in var.result_bucket_lifecycle_rules : rule.id], "raw-html-archive")
+    error_message = "raw-html-archive is declared by archive.tf and must not be listed again."
+  }
+}
+
+# The bucket's lifecycle configuration already exists and is replaced as a
+# whole, so it is imported rather than created. Before the first apply, copy
+# its rules into result_bucket_lifecycle_rules and check that the plan removes
+# none of them. Import blocks with for_each need Terraform 1.7 or later.
+import {
+  for_each = var.raw_archive_enabled ? toset([var.result_bucket]) : toset([])
+  to       = aws_s3_bucket_lifecycle_configuration.result_bucket[0]
+  id       = each.value
+}
+
+# Expires the raw result pages archived when RAW_ARCHIVE_ENABLED is set,
+# alongside the bucket's other lifecycle rules
+resource "aws_s3_bucket_lifecycle_configuration" "result_bucket" {
+  count  = var.raw_archive_enabled ? 1 : 0
+  bucket = var.result_bucket
+
+  rule {
+    id     = "raw-html-archive"
+    status = "Enabled"
+
+    filter {
+      prefix = "raw/"
+    }
+
+    expiration {
+      days = var.raw_archive_retention_days
+    }
+  }
+
+  dynamic "rule" {
+    for_each = var.result_bucket_lifecycle_rules
+    content {
+      id     = rule.value.id
+      status = rule.value.status
+
+      filter {
+        prefix = rule.value.prefix
+      }
+
+      dynamic "expiration" {
+        for_each = rule.value.expiration_days == null ? [] : [rule.value.expiration_days]
+        content {
+          days = expiration.value
+        }
+      }
+
+      dynamic "noncurrent_version_expiration" {
+        for_each = rule.value.noncurrent_version_expiration_days == null ? [] : [rule.value.noncurrent_version_expiration_days]
+        content {
+          noncurrent_days = noncurrent_version_expiration.value
+        }
+      }
+
+      dynamic "abort_incomplete_multipart_upload" {
+        for_each = rule.value.abort_incomplete_multipart_upload_days == null ? [] : [rule.value.abort_incomplete_multipart_upload_days]
+        content {
+          days_after_initiation = abort_incomplete_multipart_upload.value
+        }
+      }
+
+      dynamic "transition" {
+        for_each = rule.value.transitions
+        content {
+          days          = transition.value.days
+          storage_class = transition.value.storage_class
+        }
+      }
+    }
+  }
+}
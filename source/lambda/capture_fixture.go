@@ -0,0 +1,68 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lambda/internal"
+)
+
+func main() {
+	var key, file, device, name, fixtures string
+	flag.StringVar(&key, "key", "", "Archive key of a captured page under raw/ in RESULT_BUCKET")
+	flag.StringVar(&file, "file", "", "Page saved from a browser, instead of -key")
+	flag.StringVar(&device, "device", "", "Device the page was served to (PC or MO)")
+	flag.StringVar(&name, "name", "", "Fixture name, e.g. ads_present or paginated_adlist1")
+	flag.StringVar(&fixtures, "fixtures", "internal/testdata/serp", "Directory with <device>/<name>.html fixtures")
+	flag.Parse()
+
+	if (key == "") == (file == "") || device == "" || name == "" {
+		fmt.Println("Usage: go run capture_fixture.go (-key=raw/... | -file=page.html) -device=PC|MO -name=fixture")
+		fmt.Println("Example: go run capture_fixture.go -key=raw/basic_date=20250813/hh=9/device=PC/꽃배달.html.gz -device=PC -name=ads_present")
+		os.Exit(1)
+	}
+
+	var body []byte
+	var err error
+	if file != "" {
+		body, err = os.ReadFile(file)
+	} else {
+		body, err = readArchived(key)
+	}
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	page, err := internal.AnonymizePage(body)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	path := filepath.Join(fixtures, strings.ToLower(device), name+".html")
+	if err := os.WriteFile(path, page, 0o644); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	fmt.Printf("✅ Wrote %s (%d bytes)\n", path, len(page))
+	fmt.Println("Review it for anything identifying, then regenerate its golden file:")
+	fmt.Println("  go test ./internal -run TestExtractGolden -update")
+}
+
+// readArchived downloads a page archived by the crawler
+func readArchived(key string) ([]byte, error) {
+	cfg, err := internal.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	deps, err := internal.NewAWSDependencies(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return internal.NewS3PageArchive(deps.S3, cfg.Bucket).ReadPage(context.Background(), key)
+}
@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// archivePrefix is the key prefix of archived result pages
+const archivePrefix = "raw/"
+
+// RawPage is a result page as served, kept so it can be re-extracted later
+type RawPage struct {
+	Keyword string
+	Device  string
+
+	// Page is the page of the crawl, as in SearchResult.Page; 0 means 1
+	Page int
+
+	// CrawlMeta describes the fetch of this page
+	CrawlMeta
+
+	// Partition is the CrawledAt of the crawl's first page, which decides
+	// the partition so every page lands in the hour the crawl's results are
+	// written to; zero means CrawledAt
+	Partition time.Time
+
+	Body []byte
+}
+
+// PageArchive stores raw result pages. S3PageArchive is the production
+// implementation and MemoryArchive the in-memory fake.
+type PageArchive interface {
+	StorePage(ctx context.Context, page RawPage) error
+}
+
+type pageArchiveKey struct{}
+
+// WithPageArchive returns a context whose scrapes store every fetched page in archive
+func WithPageArchive(ctx context.Context, archive PageArchive) context.Context {
+	return context.WithValue(ctx, pageArchiveKey{}, archive)
+}
+
+// archivePage stores page in the context's archive, if any. Archival is best
+// effort: a failure is logged and does not fail the crawl.
+func archivePage(ctx context.Context, page RawPage) {
+	archive, _ := ctx.Value(pageArchiveKey{}).(PageArchive)
+	if archive == nil {
+		return
+	}
+	if err := archive.StorePage(ctx, page); err != nil {
+		log.Printf("⚠️ Failed to archive %s page for %s: %v", page.Device, page.Keyword, err)
+	}
+}
+
+// archiveKey returns raw/basic_date=YYYYMMDD/hh=H/device=D/<keyword>.html.gz
+// for result pages and .../device=D/page=N/<keyword>.html.gz for ad list
+// pages. A keyword crawled again in the same hour replaces the earlier pages.
+func archiveKey(page RawPage) string {
+	dir := "device=" + page.Device
+	if page.Page > 1 {
+		dir += fmt.Sprintf("/page=%d", page.Page)
+	}
+	partition := page.Partition
+	if partition.IsZero() {
+		partition = page.CrawledAt
+	}
+	return fmt.Sprintf("%s%s/%s/%s.html.gz",
+		archivePrefix, partitionPath(partition), dir, url.PathEscape(page.Keyword))
+}
+
+// parseArchiveKey recovers the device, keyword and page encoded in an archive key
+func parseArchiveKey(key string) (device, keyword string, page int, err error) {
+	i := strings.LastIndex(key, "/device=")
+	if i < 0 || !strings.HasSuffix(key, ".html.gz") {
+		return "", "", 0, fmt.Errorf("not an archive key: %q", key)
+	}
+	device, file, ok := strings.Cut(key[i+len("/device="):], "/")
+	if !ok || device == "" {
+		return "", "", 0, fmt.Errorf("not an archive key: %q", key)
+	}
+	page = 1
+	if dir, rest, ok := strings.Cut(file, "/"); ok {
+		n, err := strconv.Atoi(strings.TrimPrefix(dir, "page="))
+		if !strings.HasPrefix(dir, "page=") || err != nil || n < 2 {
+			return "", "", 0, fmt.Errorf("not an archive key: %q", key)
+		}
+		page, file = n, rest
+	}
+	keyword, err = url.PathUnescape(strings.TrimSuffix(file, ".html.gz"))
+	if err != nil {
+		return "", "", 0, fmt.Errorf("archive key %q: %w", key, err)
+	}
+	return device, keyword, page, nil
+}
+
+// S3PageArchive stores gzipped result pages under raw/ in the result bucket,
+// partitioned like the result outputs
+type S3PageArchive struct {
+	client s3iface.S3API
+	bucket string
+}
+
+// NewS3PageArchive returns a PageArchive writing to bucket
+func NewS3PageArchive(client s3iface.S3API, bucket string) *S3PageArchive {
+	return &S3PageArchive{client: client, bucket: bucket}
+}
+
+// StorePage uploads the gzipped page body
+func (a *S3PageArchive) StorePage(ctx context.Context, page RawPage) error {
+	buffer := new(bytes.Buffer)
+	gzWriter := gzip.NewWriter(buffer)
+	if _, err := gzWriter.Write(page.Body); err != nil {
+		return fmt.Errorf("failed to compress page: %w", err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+
+	reader := bytes.NewReader(buffer.Bytes())
+	_, err := a.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(a.bucket),
+		Key:           aws.String(archiveKey(page)),
+		Body:          reader,
+		ContentLength: aws.Int64(int64(reader.Len())),
+		ContentType:   aws.String("application/gzip"),
+		Metadata:      archiveMetadata(page.CrawlMeta),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload page to S3: %w", err)
+	}
+	return nil
+}
+
+// ReadPage returns the body of the page archived under key
+func (a *S3PageArchive) ReadPage(ctx context.Context, key string) ([]byte, error) {
+	body, _, err := readArchivedPage(ctx, a.client, a.bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archived page %s: %w", key, err)
+	}
+	return body, nil
+}
+
+// listKeys returns every key under prefix
+func listKeys(ctx context.Context, client s3iface.S3API, bucket, prefix string) ([]string, error) {
+	var keys []string
+	err := client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+// readArchivedPage downloads and decompresses an archived page and returns
+// it with the crawl metadata stored alongside
+func readArchivedPage(ctx context.Context, client s3iface.S3API, bucket, key string) ([]byte, CrawlMeta, error) {
+	out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, CrawlMeta{}, err
+	}
+	defer out.Body.Close()
+
+	gzReader, err := gzip.NewReader(out.Body)
+	if err != nil {
+		return nil, CrawlMeta{}, fmt.Errorf("%s is not gzipped: %w", key, err)
+	}
+	defer gzReader.Close()
+	body, err := io.ReadAll(gzReader)
+	if err != nil {
+		return nil, CrawlMeta{}, err
+	}
+	return body, crawlMetaFromMetadata(out.Metadata), nil
+}
+
+// Object metadata (x-amz-meta-*) keys the crawl metadata of an archived page
+// is stored under, in the canonical form the SDK returns them in
+const (
+	metaCrawledAt       = "Crawled-At"
+	metaRequestID       = "Request-Id"
+	metaUserAgent       = "User-Agent"
+	metaHTTPStatus      = "Http-Status"
+	metaLatencyMs       = "Latency-Ms"
+	metaLambdaRequestID = "Lambda-Request-Id"
+)
+
+// archiveMetadata encodes meta as S3 object metadata
+func archiveMetadata(meta CrawlMeta) map[string]*string {
+	metadata := map[string]*string{
+		metaCrawledAt:  aws.String(meta.CrawledAt.UTC().Format(time.RFC3339Nano)),
+		metaHTTPStatus: aws.String(strconv.Itoa(meta.HTTPStatus)),
+		metaLatencyMs:  aws.String(strconv.FormatInt(meta.LatencyMs, 10)),
+	}
+	for key, value := range map[string]string{
+		metaRequestID:       meta.RequestID,
+		metaUserAgent:       meta.UserAgent,
+		metaLambdaRequestID: meta.LambdaRequestID,
+	} {
+		if value != "" {
+			metadata[key] = aws.String(value)
+		}
+	}
+	return metadata
+}
+
+// crawlMetaFromMetadata decodes archiveMetadata. Pages archived before the
+// metadata was stored decode to a zero CrawlMeta.
+func crawlMetaFromMetadata(metadata map[string]*string) CrawlMeta {
+	get := func(key string) string {
+		for k, v := range metadata {
+			if strings.EqualFold(k, key) {
+				return aws.StringValue(v)
+			}
+		}
+		return ""
+	}
+	var meta CrawlMeta
+	meta.CrawledAt, _ = time.Parse(time.RFC3339Nano, get(metaCrawledAt))
+	meta.RequestID = get(metaRequestID)
+	meta.UserAgent = get(metaUserAgent)
+	meta.HTTPStatus, _ = strconv.Atoi(get(metaHTTPStatus))
+	meta.LatencyMs, _ = strconv.ParseInt(get(metaLatencyMs), 10, 64)
+	meta.LambdaRequestID = get(metaLambdaRequestID)
+	return meta
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// concurrencyLimiter adapts how many keywords are crawled at once to how
+// Naver copes, AIMD style: the limit grows by one once a limit's worth of
+// page requests in a row were healthy, and is halved on a 429 or 5xx
+// response, a timeout or network failure, or a response slower than
+// LatencyTarget. It starts at Concurrency and stays between MinConcurrency
+// and Concurrency; with no MinConcurrency it is fixed at Concurrency.
+type concurrencyLimiter struct {
+	settings InvocationSettings
+
+	mu        sync.Mutex
+	limit     int
+	active    int
+	successes int // healthy requests since the limit last changed
+
+	// lastDecrease is when the limit was last halved; failures of requests
+	// started before it were sent under the old limit and are not held
+	// against the new one
+	lastDecrease time.Time
+
+	// wake is closed and replaced whenever a slot may have come free
+	wake chan struct{}
+}
+
+func newConcurrencyLimiter(settings InvocationSettings) *concurrencyLimiter {
+	settings.Concurrency = max(settings.Concurrency, 1)
+	if settings.MinConcurrency < 1 || settings.MinConcurrency > settings.Concurrency {
+		settings.MinConcurrency = settings.Concurrency
+	}
+	return &concurrencyLimiter{settings: settings, limit: settings.Concurrency, wake: make(chan struct{})}
+}
+
+// acquire blocks until fewer keywords than the limit are being crawled and
+// takes a slot, or returns false once ctx is done
+func (c *concurrencyLimiter) acquire(ctx context.Context) bool {
+	for {
+		c.mu.Lock()
+		if c.active < c.limit {
+			c.active++
+			c.mu.Unlock()
+			return true
+		}
+		wake := c.wake
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-wake:
+		}
+	}
+}
+
+// release hands back a slot taken by acquire
+func (c *concurrencyLimiter) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.notify()
+}
+
+// current returns the limit
+func (c *concurrencyLimiter) current() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// observe adjusts the limit to the outcome of a page request started at
+// start: err is what the request returned and latency how long the response
+// took when it succeeded. Failures that say nothing about load, such as a
+// 403 or a cancelled crawl, are ignored.
+func (c *concurrencyLimiter) observe(start time.Time, latency time.Duration, err error) {
+	reason := overloadReason(latency, c.settings.LatencyTarget, err)
+	if reason == "" && err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.settings.MinConcurrency == c.settings.Concurrency {
+		return
+	}
+
+	if reason == "" {
+		c.successes++
+		if c.successes < c.limit || c.limit == c.settings.Concurrency {
+			return
+		}
+		c.setLimit(c.limit+1, "increase", "healthy responses")
+		return
+	}
+
+	if start.Before(c.lastDecrease) {
+		return
+	}
+	c.lastDecrease = time.Now()
+	if c.limit > c.settings.MinConcurrency {
+		c.setLimit(max(c.limit/2, c.settings.MinConcurrency), "decrease", reason)
+	}
+}
+
+// setLimit changes the limit and publishes the decision; c.mu must be held
+func (c *concurrencyLimiter) setLimit(limit int, decision, reason string) {
+	icon := "📈"
+	if limit < c.limit {
+		icon = "📉"
+	}
+	log.Printf("%s Concurrency limit %d -> %d (%s)", icon, c.limit, limit, reason)
+	emitMetric("ConcurrencyLimit", float64(limit), UnitCount, map[string]string{"Decision": decision})
+
+	c.limit = limit
+	c.successes = 0
+	c.notify()
+}
+
+// notify wakes the goroutines waiting in acquire; c.mu must be held
+func (c *concurrencyLimiter) notify() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// overloadReason tells why a request suggests Naver is overloaded, or
+// returns "" when it does not. A zero target ignores latency.
+func overloadReason(latency, target time.Duration, err error) string {
+	var scrapeErr *ScrapeError
+	switch {
+	case err == nil:
+		if target > 0 && latency > target {
+			return "slow response"
+		}
+		return ""
+	case !errors.As(err, &scrapeErr) || !scrapeErr.Retryable:
+		return ""
+	case scrapeErr.StatusCode == http.StatusTooManyRequests:
+		return "429 response"
+	case scrapeErr.StatusCode >= 500:
+		return "5xx response"
+	case scrapeErr.StatusCode == 0:
+		return "timeout or network failure"
+	}
+	return ""
+}
+
+type concurrencyLimiterKey struct{}
+
+// withConcurrencyLimiter reports the page requests made with ctx to limiter
+func withConcurrencyLimiter(ctx context.Context, limiter *concurrencyLimiter) context.Context {
+	return context.WithValue(ctx, concurrencyLimiterKey{}, limiter)
+}
+
+// observeRequest reports a page request to the limiter attached to ctx, if any
+func observeRequest(ctx context.Context, start time.Time, latency time.Duration, err error) {
+	if limiter, ok := ctx.Value(concurrencyLimiterKey{}).(*concurrencyLimiter); ok {
+		limiter.observe(start, latency, err)
+	}
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterAIMD(t *testing.T) {
+	limiter := newConcurrencyLimiter(InvocationSettings{Concurrency: 8, MinConcurrency: 2, LatencyTarget: time.Second})
+	healthy := func(n int) {
+		for range n {
+			limiter.observe(time.Now(), 100*time.Millisecond, nil)
+		}
+	}
+
+	// The limit starts at the configured concurrency and cannot grow past it
+	healthy(8)
+	if got := limiter.current(); got != 8 {
+		t.Fatalf("got limit %d, want it to start and stay at 8", got)
+	}
+
+	// An overloaded response halves it, once for the requests sent under the old limit
+	start := time.Now()
+	limiter.observe(start, 0, &ScrapeError{StatusCode: 429, Retryable: true, Err: errors.New("429")})
+	limiter.observe(start, 0, &ScrapeError{StatusCode: 503, Retryable: true, Err: errors.New("503")})
+	if got := limiter.current(); got != 4 {
+		t.Fatalf("got limit %d after concurrent failures, want 4", got)
+	}
+	limiter.observe(time.Now(), 2*time.Second, nil)
+	if got := limiter.current(); got != 2 {
+		t.Fatalf("got limit %d after a slow response, want 2", got)
+	}
+	limiter.observe(time.Now(), 0, &ScrapeError{Retryable: true, Err: context.DeadlineExceeded})
+	if got := limiter.current(); got != 2 {
+		t.Fatalf("got limit %d after a timeout, want the floor of 2", got)
+	}
+
+	// Failures that say nothing about load neither raise nor lower it
+	limiter.observe(time.Now(), 0, &ScrapeError{StatusCode: 403, Err: ErrBlocked})
+	limiter.observe(time.Now(), 0, &ScrapeError{Err: context.Canceled})
+	healthy(1)
+	if got := limiter.current(); got != 2 {
+		t.Errorf("got limit %d, want 2", got)
+	}
+
+	// A limit's worth of healthy requests in a row raises the limit by one
+	healthy(1)
+	if got := limiter.current(); got != 3 {
+		t.Errorf("got limit %d after 2 healthy requests in a row, want 3", got)
+	}
+	healthy(3 + 4 + 5 + 6 + 7 + 8)
+	if got := limiter.current(); got != 8 {
+		t.Errorf("got limit %d, want it back at 8", got)
+	}
+}
+
+func TestConcurrencyLimiterAcquire(t *testing.T) {
+	limiter := newConcurrencyLimiter(InvocationSettings{Concurrency: 2, MinConcurrency: 1})
+	limiter.observe(time.Now(), 0, &ScrapeError{StatusCode: 429, Retryable: true, Err: errors.New("429")})
+	ctx := context.Background()
+	if !limiter.acquire(ctx) {
+		t.Fatal("first slot not acquired")
+	}
+
+	// The second waits until the limit is raised
+	acquired := make(chan bool)
+	go func() { acquired <- limiter.acquire(ctx) }()
+	select {
+	case <-acquired:
+		t.Fatal("second slot acquired over the limit of 1")
+	case <-time.After(20 * time.Millisecond):
+	}
+	limiter.observe(time.Now(), 0, nil)
+	if !<-acquired {
+		t.Fatal("second slot not acquired once the limit was raised")
+	}
+
+	// A done context gives up waiting
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if limiter.acquire(cancelled) {
+		t.Error("slot acquired over the limit with a done context")
+	}
+	limiter.release()
+	if !limiter.acquire(cancelled) {
+		t.Error("free slot not acquired")
+	}
+}
+
+func TestConcurrencyLimiterFixed(t *testing.T) {
+	limiter := newConcurrencyLimiter(InvocationSettings{Concurrency: 3})
+	limiter.observe(time.Now(), 0, &ScrapeError{StatusCode: 429, Retryable: true, Err: errors.New("429")})
+	if got := limiter.current(); got != 3 {
+		t.Errorf("got limit %d without a minimum, want it fixed at 3", got)
+	}
+}
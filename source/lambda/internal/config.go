@@ -0,0 +1,426 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Environment variables read by LoadConfig
+const (
+	RegionEnv      = "AWS_REGION"
+	QueueURLEnv    = "QUEUE_URL"
+	BucketEnv      = "RESULT_BUCKET"
+	SQSEndpointEnv = "SQS_ENDPOINT_URL"
+	S3EndpointEnv  = "S3_ENDPOINT_URL"
+
+	// The search base URLs replace the scheme and host of the desktop and
+	// mobile search URLs, e.g. to crawl a FakeNaver server
+	DesktopSearchBaseURLEnv = "DESKTOP_SEARCH_BASE_URL"
+	MobileSearchBaseURLEnv  = "MOBILE_SEARCH_BASE_URL"
+
+	// DeadLetterQueueURLEnv is the queue dead letters are sent to. Without it
+	// dead letters are only written to the log before the message is deleted.
+	DeadLetterQueueURLEnv = "DLQ_URL"
+
+	// MaxReceiveCountEnv caps how often a keyword is attempted before it is dead-lettered
+	MaxReceiveCountEnv = "MAX_RECEIVE_COUNT"
+
+	RetryMaxAttemptsEnv   = "SCRAPE_RETRY_MAX_ATTEMPTS"
+	RetryBaseDelayEnv     = "SCRAPE_RETRY_BASE_DELAY"
+	RetryMaxDelayEnv      = "SCRAPE_RETRY_MAX_DELAY"
+	RetryKeywordBudgetEnv = "SCRAPE_RETRY_KEYWORD_BUDGET"
+
+	// AdPageDepthEnv is how many pages are crawled per keyword: 1 is the
+	// result page only, higher values follow the ad list pages
+	AdPageDepthEnv = "AD_PAGE_DEPTH"
+
+	// ResultFormatEnv selects the format results are written in: csv
+	// (gzip CSV, the default) or parquet
+	ResultFormatEnv = "RESULT_FORMAT"
+
+	// Results are buffered across an invocation and written as one object
+	// per device and hour once a batch holds this many keyword crawls or
+	// records; 0 disables a limit
+	ResultBatchMaxCrawlsEnv  = "RESULT_BATCH_MAX_CRAWLS"
+	ResultBatchMaxRecordsEnv = "RESULT_BATCH_MAX_RECORDS"
+
+	// Received messages are hidden for VisibilityTimeoutEnv and extended by
+	// that much every VisibilityHeartbeatEnv while they are worked on; a zero
+	// heartbeat interval turns the extensions off
+	VisibilityTimeoutEnv   = "VISIBILITY_TIMEOUT"
+	VisibilityHeartbeatEnv = "VISIBILITY_HEARTBEAT_INTERVAL"
+
+	// An invocation receives batches of ReceiveBatchSizeEnv messages and
+	// crawls CrawlConcurrencyEnv keywords at a time for as long as another
+	// batch fits before the deadline less DeadlineSafetyMarginEnv
+	ReceiveBatchSizeEnv     = "RECEIVE_BATCH_SIZE"
+	CrawlConcurrencyEnv     = "CRAWL_CONCURRENCY"
+	DeadlineSafetyMarginEnv = "DEADLINE_SAFETY_MARGIN"
+
+	// The number of keywords crawled at once starts at CrawlConcurrencyEnv
+	// and adapts down to CrawlConcurrencyMinEnv and back: it grows while Naver
+	// answers within CrawlLatencyTargetEnv and is halved on 429, 5xx,
+	// timeouts and slower responses
+	CrawlConcurrencyMinEnv = "CRAWL_CONCURRENCY_MIN"
+	CrawlLatencyTargetEnv  = "CRAWL_LATENCY_TARGET"
+
+	// Requests to each Naver host are limited to RateLimitRPSEnv per second,
+	// across devices and workers, with bursts of up to RateLimitBurstEnv;
+	// a zero rate turns the limit off
+	RateLimitRPSEnv   = "RATE_LIMIT_RPS"
+	RateLimitBurstEnv = "RATE_LIMIT_BURST"
+
+	// MetricsNamespaceEnv overrides the CloudWatch namespace metrics are published under
+	MetricsNamespaceEnv = "METRICS_NAMESPACE"
+
+	// RawArchiveEnv turns on archival of raw result pages under raw/ in the
+	// result bucket; their expiry is a bucket lifecycle rule in infra/
+	RawArchiveEnv = "RAW_ARCHIVE_ENABLED"
+
+	// The S3 object takes precedence over the local file; without either
+	// the embedded selectors.json is used
+	SelectorConfigFileEnv    = "SELECTOR_CONFIG_FILE"
+	SelectorConfigS3Env      = "SELECTOR_CONFIG_S3_URI"
+	SelectorConfigRefreshEnv = "SELECTOR_CONFIG_REFRESH_INTERVAL"
+)
+
+// Defaults used when the corresponding variable is unset
+const (
+	DefaultRegion   = "ap-northeast-2"
+	DefaultQueueURL = "https://sqs.ap-northeast-2.amazonaws.com/289023186990/skale-hourly-keyword-queue"
+	DefaultBucket   = "skale-crawling-manager"
+
+	defaultMetricsNamespace        = "NaverSACrawler"
+	defaultMaxReceiveCount         = 5
+	defaultSelectorRefreshInterval = 5 * time.Minute
+	defaultAdPageDepth             = 1
+	defaultBatchMaxCrawls          = 100
+	defaultBatchMaxRecords         = 20000
+	defaultVisibilityTimeout       = 30 * time.Second
+	defaultVisibilityHeartbeat     = 10 * time.Second
+	defaultReceiveBatchSize        = 10
+	defaultCrawlConcurrency        = 10
+	defaultDeadlineSafetyMargin    = 5 * time.Second
+	defaultCrawlConcurrencyMin     = 2
+	defaultCrawlLatencyTarget      = 2 * time.Second
+	defaultRateLimitRPS            = 0
+	defaultRateLimitBurst          = 10
+
+	// maxReceiveBatchSize is the most messages SQS returns from one receive
+	maxReceiveBatchSize = 10
+
+	// maxVisibilityTimeout is the longest visibility timeout SQS accepts
+	maxVisibilityTimeout = 12 * time.Hour
+)
+
+// Config is the runtime configuration of the crawler. The same image can run
+// against staging, prod or local stand-ins (ElasticMQ, MinIO) by changing
+// environment variables only.
+type Config struct {
+	Region   string
+	QueueURL string
+	Bucket   string
+
+	// SQSEndpoint and S3Endpoint override the AWS endpoints, e.g. for local stand-ins
+	SQSEndpoint string
+	S3Endpoint  string
+
+	// DesktopSearchBaseURL and MobileSearchBaseURL override the Naver search hosts
+	DesktopSearchBaseURL string
+	MobileSearchBaseURL  string
+
+	DeadLetterQueueURL string
+	MaxReceiveCount    int
+
+	// AdPageDepth is how many pages are crawled per keyword and device
+	AdPageDepth int
+
+	// ResultFormat is the format results are written in, see NewEncoder
+	ResultFormat string
+
+	// Batch bounds the result batches written to the bucket
+	Batch BatchLimits
+
+	Visibility VisibilitySettings
+
+	Invocation InvocationSettings
+
+	RateLimit RateLimit
+
+	Retry RetryPolicy
+
+	// MetricsNamespace is the CloudWatch namespace metrics are published
+	// under, see ApplyMetricsNamespace
+	MetricsNamespace string
+
+	Selectors SelectorSource
+
+	Archive ArchiveSettings
+}
+
+// ArchiveSettings controls archival of raw result pages for reprocessing
+type ArchiveSettings struct {
+	Enabled bool
+}
+
+// LoadConfig reads the configuration from the environment, applying defaults
+// for unset variables and rejecting malformed or invalid values
+func LoadConfig() (Config, error) {
+	env := &envReader{}
+	// The default floor of the concurrency limit must not exceed a lower concurrency
+	concurrency := env.int(CrawlConcurrencyEnv, defaultCrawlConcurrency)
+	cfg := Config{
+		Region:               env.str(RegionEnv, DefaultRegion),
+		QueueURL:             env.str(QueueURLEnv, DefaultQueueURL),
+		Bucket:               env.str(BucketEnv, DefaultBucket),
+		SQSEndpoint:          env.str(SQSEndpointEnv, ""),
+		S3Endpoint:           env.str(S3EndpointEnv, ""),
+		DesktopSearchBaseURL: env.str(DesktopSearchBaseURLEnv, ""),
+		MobileSearchBaseURL:  env.str(MobileSearchBaseURLEnv, ""),
+		DeadLetterQueueURL:   env.str(DeadLetterQueueURLEnv, ""),
+		MaxReceiveCount:      env.int(MaxReceiveCountEnv, defaultMaxReceiveCount),
+		AdPageDepth:          env.int(AdPageDepthEnv, defaultAdPageDepth),
+		ResultFormat:         env.str(ResultFormatEnv, FormatCSV),
+		Batch: BatchLimits{
+			MaxCrawls:  env.int(ResultBatchMaxCrawlsEnv, defaultBatchMaxCrawls),
+			MaxRecords: env.int(ResultBatchMaxRecordsEnv, defaultBatchMaxRecords),
+		},
+		Visibility: VisibilitySettings{
+			Timeout:  env.duration(VisibilityTimeoutEnv, defaultVisibilityTimeout),
+			Interval: env.duration(VisibilityHeartbeatEnv, defaultVisibilityHeartbeat),
+		},
+		Invocation: InvocationSettings{
+			BatchSize:      env.int(ReceiveBatchSizeEnv, defaultReceiveBatchSize),
+			Concurrency:    concurrency,
+			MinConcurrency: env.int(CrawlConcurrencyMinEnv, min(defaultCrawlConcurrencyMin, concurrency)),
+			LatencyTarget:  env.duration(CrawlLatencyTargetEnv, defaultCrawlLatencyTarget),
+			SafetyMargin:   env.duration(DeadlineSafetyMarginEnv, defaultDeadlineSafetyMargin),
+		},
+		RateLimit: RateLimit{
+			RequestsPerSecond: env.float(RateLimitRPSEnv, defaultRateLimitRPS),
+			Burst:             env.int(RateLimitBurstEnv, defaultRateLimitBurst),
+		},
+		Retry: RetryPolicy{
+			MaxAttempts:   env.int(RetryMaxAttemptsEnv, DefaultRetryPolicy.MaxAttempts),
+			BaseDelay:     env.duration(RetryBaseDelayEnv, DefaultRetryPolicy.BaseDelay),
+			MaxDelay:      env.duration(RetryMaxDelayEnv, DefaultRetryPolicy.MaxDelay),
+			KeywordBudget: env.int(RetryKeywordBudgetEnv, DefaultRetryPolicy.KeywordBudget),
+		},
+		MetricsNamespace: env.str(MetricsNamespaceEnv, defaultMetricsNamespace),
+		Selectors: SelectorSource{
+			File:            env.str(SelectorConfigFileEnv, ""),
+			S3URI:           env.str(SelectorConfigS3Env, ""),
+			RefreshInterval: env.duration(SelectorConfigRefreshEnv, defaultSelectorRefreshInterval),
+		},
+		Archive: ArchiveSettings{
+			Enabled: env.bool(RawArchiveEnv, false),
+		},
+	}
+	if err := errors.Join(env.errs...); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that the configuration is usable
+func (c Config) Validate() error {
+	var errs []error
+	if c.Region == "" {
+		errs = append(errs, errors.New("region is required"))
+	}
+	if c.Bucket == "" {
+		errs = append(errs, errors.New("result bucket is required"))
+	}
+	if c.MetricsNamespace == "" {
+		errs = append(errs, errors.New("metrics namespace is required"))
+	}
+	for name, value := range map[string]string{
+		"queue URL":             c.QueueURL,
+		"dead-letter queue URL": c.DeadLetterQueueURL,
+		"SQS endpoint":          c.SQSEndpoint,
+		"S3 endpoint":           c.S3Endpoint,
+	} {
+		if value == "" && name != "queue URL" {
+			continue
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s %q is not an absolute URL", name, value))
+		}
+	}
+	if c.MaxReceiveCount < 1 {
+		errs = append(errs, fmt.Errorf("max receive count must be at least 1, got %d", c.MaxReceiveCount))
+	}
+	if c.AdPageDepth < 1 || c.AdPageDepth > MaxAdPageDepth {
+		errs = append(errs, fmt.Errorf("ad page depth must be between 1 and %d, got %d", MaxAdPageDepth, c.AdPageDepth))
+	}
+	if _, err := NewEncoder(c.ResultFormat); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Batch.MaxCrawls < 0 || c.Batch.MaxRecords < 0 {
+		errs = append(errs, fmt.Errorf("result batch limits must not be negative, got %d crawls and %d records", c.Batch.MaxCrawls, c.Batch.MaxRecords))
+	}
+	// SQS counts visibility in whole seconds
+	if c.Visibility.Timeout < time.Second || c.Visibility.Timeout > maxVisibilityTimeout {
+		errs = append(errs, fmt.Errorf("visibility timeout must be between 1s and %s, got %s", maxVisibilityTimeout, c.Visibility.Timeout))
+	}
+	if c.Visibility.Interval < 0 || c.Visibility.Interval >= c.Visibility.Timeout {
+		errs = append(errs, fmt.Errorf("visibility heartbeat interval must be under the timeout (%s), got %s", c.Visibility.Timeout, c.Visibility.Interval))
+	}
+	if c.Invocation.BatchSize < 1 || c.Invocation.BatchSize > maxReceiveBatchSize {
+		errs = append(errs, fmt.Errorf("receive batch size must be between 1 and %d, got %d", maxReceiveBatchSize, c.Invocation.BatchSize))
+	}
+	if c.Invocation.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("crawl concurrency must be at least 1, got %d", c.Invocation.Concurrency))
+	}
+	if c.Invocation.MinConcurrency < 1 || c.Invocation.MinConcurrency > c.Invocation.Concurrency {
+		errs = append(errs, fmt.Errorf("minimum crawl concurrency must be between 1 and the crawl concurrency (%d), got %d", c.Invocation.Concurrency, c.Invocation.MinConcurrency))
+	}
+	if c.Invocation.LatencyTarget < 0 {
+		errs = append(errs, fmt.Errorf("crawl latency target must not be negative, got %s", c.Invocation.LatencyTarget))
+	}
+	// The margin has to fit the uploads and deletes that follow the crawls
+	if c.Invocation.SafetyMargin < minSafetyMargin {
+		errs = append(errs, fmt.Errorf("deadline safety margin must be at least %s, got %s", minSafetyMargin, c.Invocation.SafetyMargin))
+	}
+	if c.RateLimit.RequestsPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("rate limit must not be negative, got %g requests per second", c.RateLimit.RequestsPerSecond))
+	}
+	if c.RateLimit.RequestsPerSecond > 0 && c.RateLimit.Burst < 1 {
+		errs = append(errs, fmt.Errorf("rate limit burst must be at least 1, got %d", c.RateLimit.Burst))
+	}
+	if c.Retry.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("retry max attempts must be at least 1, got %d", c.Retry.MaxAttempts))
+	}
+	// Every page of a crawl should be able to retry at least once
+	if c.Retry.KeywordBudget < max(c.AdPageDepth, 1) {
+		errs = append(errs, fmt.Errorf("retry keyword budget must be at least the ad page depth (%d), got %d", max(c.AdPageDepth, 1), c.Retry.KeywordBudget))
+	}
+	if c.Retry.BaseDelay < 0 || c.Retry.MaxDelay < c.Retry.BaseDelay {
+		errs = append(errs, fmt.Errorf("retry delays must satisfy 0 <= base (%s) <= max (%s)", c.Retry.BaseDelay, c.Retry.MaxDelay))
+	}
+	if c.Selectors.RefreshInterval <= 0 {
+		errs = append(errs, fmt.Errorf("selector refresh interval must be positive, got %s", c.Selectors.RefreshInterval))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return nil
+}
+
+// NewAWSSession creates the AWS session shared by the SQS and S3 clients
+func NewAWSSession(cfg Config) (*session.Session, error) {
+	return session.NewSession(&aws.Config{Region: aws.String(cfg.Region)})
+}
+
+// NewSQSClient creates an SQS client, pointed at cfg.SQSEndpoint when set
+func NewSQSClient(sess *session.Session, cfg Config) *sqs.SQS {
+	config := aws.NewConfig()
+	if cfg.SQSEndpoint != "" {
+		config = config.WithEndpoint(cfg.SQSEndpoint)
+	}
+	return sqs.New(sess, config)
+}
+
+// NewS3Client creates an S3 client. A custom endpoint (e.g. MinIO) uses
+// path-style addressing since local stand-ins rarely resolve bucket subdomains.
+func NewS3Client(sess *session.Session, cfg Config) *s3.S3 {
+	config := aws.NewConfig()
+	if cfg.S3Endpoint != "" {
+		config = config.WithEndpoint(cfg.S3Endpoint).WithS3ForcePathStyle(true)
+	}
+	return s3.New(sess, config)
+}
+
+// ApplySearchBaseURLs points the desktop and mobile profiles at the search
+// base URLs set in cfg; profiles without an override keep the Naver hosts
+func ApplySearchBaseURLs(cfg Config) error {
+	for device, base := range map[string]string{
+		DeviceDesktop: cfg.DesktopSearchBaseURL,
+		DeviceMobile:  cfg.MobileSearchBaseURL,
+	} {
+		if base == "" {
+			continue
+		}
+		if err := SetSearchBaseURL(device, base); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envReader reads typed environment variables and collects parse errors
+type envReader struct {
+	errs []error
+}
+
+func (r *envReader) str(key, defaultValue string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (r *envReader) int(key string, defaultValue int) int {
+	value := r.str(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s=%q is not an integer", key, value))
+		return defaultValue
+	}
+	return n
+}
+
+func (r *envReader) float(key string, defaultValue float64) float64 {
+	value := r.str(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s=%q is not a number", key, value))
+		return defaultValue
+	}
+	return f
+}
+
+func (r *envReader) bool(key string, defaultValue bool) bool {
+	value := r.str(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s=%q is not a boolean", key, value))
+		return defaultValue
+	}
+	return b
+}
+
+func (r *envReader) duration(key string, defaultValue time.Duration) time.Duration {
+	value := r.str(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s=%q is not a duration (e.g. 30s)", key, value))
+		return defaultValue
+	}
+	return d
+}
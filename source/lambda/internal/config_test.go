@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configEnv lists every variable LoadConfig reads
+var configEnv = []string{
+	RegionEnv, QueueURLEnv, BucketEnv, SQSEndpointEnv, S3EndpointEnv,
+	DesktopSearchBaseURLEnv, MobileSearchBaseURLEnv, DeadLetterQueueURLEnv, MaxReceiveCountEnv,
+	RetryMaxAttemptsEnv, RetryBaseDelayEnv, RetryMaxDelayEnv, RetryKeywordBudgetEnv,
+	AdPageDepthEnv, ResultFormatEnv, ResultBatchMaxCrawlsEnv, ResultBatchMaxRecordsEnv,
+	VisibilityTimeoutEnv, VisibilityHeartbeatEnv, ReceiveBatchSizeEnv, CrawlConcurrencyEnv,
+	DeadlineSafetyMarginEnv, CrawlConcurrencyMinEnv, CrawlLatencyTargetEnv,
+	RateLimitRPSEnv, RateLimitBurstEnv, MetricsNamespaceEnv, RawArchiveEnv,
+	SelectorConfigFileEnv, SelectorConfigS3Env, SelectorConfigRefreshEnv,
+}
+
+// setConfigEnv clears every config variable, so the defaults apply, and sets env
+func setConfigEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, key := range configEnv {
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setConfigEnv(t, nil)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		Region:          DefaultRegion,
+		QueueURL:        DefaultQueueURL,
+		Bucket:          DefaultBucket,
+		MaxReceiveCount: 5,
+		AdPageDepth:     1,
+		ResultFormat:    FormatCSV,
+		Batch:           BatchLimits{MaxCrawls: 100, MaxRecords: 20000},
+		Visibility:      VisibilitySettings{Timeout: 30 * time.Second, Interval: 10 * time.Second},
+		Invocation: InvocationSettings{
+			BatchSize:      10,
+			Concurrency:    10,
+			MinConcurrency: 2,
+			LatencyTarget:  2 * time.Second,
+			SafetyMargin:   5 * time.Second,
+		},
+		RateLimit:        RateLimit{Burst: 10},
+		Retry:            DefaultRetryPolicy,
+		MetricsNamespace: "NaverSACrawler",
+		Selectors:        SelectorSource{RefreshInterval: 5 * time.Minute},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got defaults\n%+v\nwant\n%+v", cfg, want)
+	}
+}
+
+func TestLoadConfigReadsEnvironment(t *testing.T) {
+	for _, tc := range []struct {
+		env  map[string]string
+		want func(Config) any
+		is   any
+	}{
+		{map[string]string{QueueURLEnv: " https://sqs.example/queue "}, func(c Config) any { return c.QueueURL }, "https://sqs.example/queue"},
+		{map[string]string{MaxReceiveCountEnv: "3"}, func(c Config) any { return c.MaxReceiveCount }, 3},
+		{map[string]string{ResultFormatEnv: FormatParquet}, func(c Config) any { return c.ResultFormat }, FormatParquet},
+		{map[string]string{VisibilityTimeoutEnv: "1m"}, func(c Config) any { return c.Visibility.Timeout }, time.Minute},
+		{map[string]string{RateLimitRPSEnv: "2.5"}, func(c Config) any { return c.RateLimit.RequestsPerSecond }, 2.5},
+		{map[string]string{RawArchiveEnv: "true"}, func(c Config) any { return c.Archive.Enabled }, true},
+		{map[string]string{MetricsNamespaceEnv: "Staging"}, func(c Config) any { return c.MetricsNamespace }, "Staging"},
+		// The default floor of the concurrency limit follows a lower concurrency
+		{map[string]string{CrawlConcurrencyEnv: "1"}, func(c Config) any { return c.Invocation.MinConcurrency }, 1},
+		{map[string]string{CrawlConcurrencyEnv: "1", CrawlConcurrencyMinEnv: "1"}, func(c Config) any { return c.Invocation.Concurrency }, 1},
+	} {
+		t.Run(fmt.Sprint(tc.env), func(t *testing.T) {
+			setConfigEnv(t, tc.env)
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tc.want(cfg); got != tc.is {
+				t.Errorf("got %v, want %v", got, tc.is)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejectsMalformedValues(t *testing.T) {
+	for key, tc := range map[string]struct{ value, want string }{
+		MaxReceiveCountEnv:       {"five", "is not an integer"},
+		RateLimitRPSEnv:          {"fast", "is not a number"},
+		RawArchiveEnv:            {"yes please", "is not a boolean"},
+		DeadlineSafetyMarginEnv:  {"5", "is not a duration"},
+		SelectorConfigRefreshEnv: {"5 minutes", "is not a duration"},
+	} {
+		t.Run(key, func(t *testing.T) {
+			setConfigEnv(t, map[string]string{key: tc.value})
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), key) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want %s %s", err, key, tc.want)
+			}
+		})
+	}
+
+	// Every malformed value is reported, not only the first
+	setConfigEnv(t, map[string]string{AdPageDepthEnv: "x", ReceiveBatchSizeEnv: "y"})
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), AdPageDepthEnv) || !strings.Contains(err.Error(), ReceiveBatchSizeEnv) {
+		t.Errorf("got %v, want both variables reported", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	setConfigEnv(t, nil)
+	valid, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		want   string // part of the error; empty when the config is valid
+	}{
+		{"defaults", func(*Config) {}, ""},
+		{"no region", func(c *Config) { c.Region = "" }, "region is required"},
+		{"no bucket", func(c *Config) { c.Bucket = "" }, "result bucket is required"},
+		{"no metrics namespace", func(c *Config) { c.MetricsNamespace = "" }, "metrics namespace is required"},
+		{"no queue URL", func(c *Config) { c.QueueURL = "" }, "queue URL"},
+		{"relative queue URL", func(c *Config) { c.QueueURL = "skale-hourly-keyword-queue" }, "queue URL"},
+		{"relative dead-letter queue URL", func(c *Config) { c.DeadLetterQueueURL = "dlq" }, "dead-letter queue URL"},
+		{"dead-letter queue URL", func(c *Config) { c.DeadLetterQueueURL = "https://sqs.example/dlq" }, ""},
+		{"relative SQS endpoint", func(c *Config) { c.SQSEndpoint = "localhost:9324" }, "SQS endpoint"},
+		{"relative S3 endpoint", func(c *Config) { c.S3Endpoint = "minio" }, "S3 endpoint"},
+		{"local endpoints", func(c *Config) { c.SQSEndpoint, c.S3Endpoint = "http://localhost:9324", "http://localhost:9000" }, ""},
+		{"no receives", func(c *Config) { c.MaxReceiveCount = 0 }, "max receive count"},
+		{"no ad pages", func(c *Config) { c.AdPageDepth = 0 }, "ad page depth"},
+		{"too many ad pages", func(c *Config) { c.AdPageDepth = MaxAdPageDepth + 1 }, "ad page depth"},
+		{"deepest ad pages", func(c *Config) { c.AdPageDepth, c.Retry.KeywordBudget = MaxAdPageDepth, MaxAdPageDepth }, ""},
+		{"unknown format", func(c *Config) { c.ResultFormat = "json" }, "json"},
+		{"negative batch limit", func(c *Config) { c.Batch.MaxRecords = -1 }, "result batch limits"},
+		{"unlimited batches", func(c *Config) { c.Batch = BatchLimits{} }, ""},
+		{"sub-second visibility", func(c *Config) { c.Visibility.Timeout = 500 * time.Millisecond }, "visibility timeout"},
+		{"visibility over 12h", func(c *Config) { c.Visibility.Timeout = 13 * time.Hour }, "visibility timeout"},
+		{"negative heartbeat", func(c *Config) { c.Visibility.Interval = -time.Second }, "heartbeat interval"},
+		{"heartbeat at the timeout", func(c *Config) { c.Visibility.Interval = c.Visibility.Timeout }, "heartbeat interval"},
+		{"no heartbeat", func(c *Config) { c.Visibility.Interval = 0 }, ""},
+		{"empty receives", func(c *Config) { c.Invocation.BatchSize = 0 }, "receive batch size"},
+		{"receives over 10", func(c *Config) { c.Invocation.BatchSize = 11 }, "receive batch size"},
+		{"no concurrency", func(c *Config) { c.Invocation.Concurrency, c.Invocation.MinConcurrency = 0, 0 }, "crawl concurrency must be at least 1"},
+		{"no minimum concurrency", func(c *Config) { c.Invocation.MinConcurrency = 0 }, "minimum crawl concurrency"},
+		{"minimum above concurrency", func(c *Config) { c.Invocation.MinConcurrency = c.Invocation.Concurrency + 1 }, "minimum crawl concurrency"},
+		{"negative latency target", func(c *Config) { c.Invocation.LatencyTarget = -time.Second }, "latency target"},
+		{"margin below the persist windows", func(c *Config) { c.Invocation.SafetyMargin = minSafetyMargin - time.Millisecond }, "deadline safety margin"},
+		{"negative rate limit", func(c *Config) { c.RateLimit.RequestsPerSecond = -1 }, "rate limit must not be negative"},
+		{"rate limit without burst", func(c *Config) { c.RateLimit = RateLimit{RequestsPerSecond: 10} }, "rate limit burst"},
+		{"no rate limit", func(c *Config) { c.RateLimit = RateLimit{} }, ""},
+		{"no attempts", func(c *Config) { c.Retry.MaxAttempts = 0 }, "retry max attempts"},
+		{"budget below the ad pages", func(c *Config) { c.AdPageDepth, c.Retry.KeywordBudget = 3, 2 }, "retry keyword budget"},
+		{"negative base delay", func(c *Config) { c.Retry.BaseDelay = -time.Millisecond }, "retry delays"},
+		{"max delay below base", func(c *Config) { c.Retry.MaxDelay = c.Retry.BaseDelay / 2 }, "retry delays"},
+		{"no selector refresh", func(c *Config) { c.Selectors.RefreshInterval = 0 }, "selector refresh interval"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := valid
+			tc.change(&cfg)
+			err := cfg.Validate()
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("got %v, want valid", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error about %q", err, tc.want)
+			}
+		})
+	}
+
+	// Every broken rule is reported, not only the first
+	broken := valid
+	broken.Region, broken.MaxReceiveCount = "", 0
+	if err := broken.Validate(); err == nil || !strings.Contains(err.Error(), "region") || !strings.Contains(err.Error(), "max receive count") {
+		t.Errorf("got %v, want both rules reported", err)
+	}
+}
+
+func TestApplyMetricsNamespace(t *testing.T) {
+	defer metricsNamespace.Store(metricsNamespace.Load())
+
+	ApplyMetricsNamespace(Config{MetricsNamespace: "Staging"})
+	if got := currentMetricsNamespace(); got != "Staging" {
+		t.Errorf("got namespace %q, want Staging", got)
+	}
+	ApplyMetricsNamespace(Config{})
+	if got := currentMetricsNamespace(); got != defaultMetricsNamespace {
+		t.Errorf("got namespace %q without one configured, want %q", got, defaultMetricsNamespace)
+	}
+}
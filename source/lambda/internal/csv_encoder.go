@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CSVEncoder writes each table as a gzip CSV with a header row. List
+// extensions are JSON arrays and empty values empty cells.
+type CSVEncoder struct{}
+
+func (CSVEncoder) Extension() string   { return ".csv.gz" }
+func (CSVEncoder) ContentType() string { return "application/gzip" }
+
+// TablePrefix is empty: CSV keeps the original data/, organic/ and sections/
+func (CSVEncoder) TablePrefix() string { return "" }
+
+// crawlMetaHeader names the CrawlMeta columns appended to every table, after
+// the table's own columns so the existing ones keep their positions
+var crawlMetaHeader = []string{"crawled_at", "request_id", "user_agent", "http_status", "latency_ms", "lambda_request_id"}
+
+// crawlMetaCells returns the cells for crawlMetaHeader
+func crawlMetaCells(meta CrawlMeta) []string {
+	return []string{
+		timeCell(meta.CrawledAt),
+		meta.RequestID,
+		meta.UserAgent,
+		strconv.Itoa(meta.HTTPStatus),
+		strconv.FormatInt(meta.LatencyMs, 10),
+		meta.LambdaRequestID,
+	}
+}
+
+func (CSVEncoder) EncodeAds(ads []SearchResult) ([]byte, error) {
+	// CSV 헤더 작성
+	header := []string{"query", "device", "rank", "site_name", "display_url", "title", "description",
+		"landing_url", "ad_block", "sitelinks", "phone", "price", "promotion", "thumbnails", "page"}
+	header = append(header, crawlMetaHeader...)
+
+	// 데이터 작성
+	records := make([][]string, len(ads))
+	for i, item := range ads {
+		// Device values are already optimized in scrapers (PC/Mobile)
+		records[i] = []string{
+			item.Query,
+			item.Device,
+			strconv.Itoa(item.Rank),
+			item.SiteName,
+			item.DisplayURL,
+			item.Title,
+			item.Description,
+			item.LandingURL,
+			item.AdBlock,
+			jsonCell(item.Extensions.Sitelinks),
+			item.Extensions.Phone,
+			item.Extensions.Price,
+			item.Extensions.Promotion,
+			jsonCell(item.Extensions.Thumbnails),
+			strconv.Itoa(item.Page),
+		}
+		records[i] = append(records[i], crawlMetaCells(item.CrawlMeta)...)
+	}
+	return encodeCSV(header, records)
+}
+
+func (CSVEncoder) EncodeOrganic(results []OrganicResult) ([]byte, error) {
+	header := []string{"query", "device", "rank", "title", "url", "display_url", "description"}
+	header = append(header, crawlMetaHeader...)
+	records := make([][]string, len(results))
+	for i, item := range results {
+		records[i] = []string{
+			item.Query,
+			item.Device,
+			strconv.Itoa(item.Rank),
+			item.Title,
+			item.URL,
+			item.DisplayURL,
+			item.Description,
+		}
+		records[i] = append(records[i], crawlMetaCells(item.CrawlMeta)...)
+	}
+	return encodeCSV(header, records)
+}
+
+func (CSVEncoder) EncodeSections(sections []SerpSection) ([]byte, error) {
+	header := []string{"query", "device", "position", "type", "heading"}
+	header = append(header, crawlMetaHeader...)
+	records := make([][]string, len(sections))
+	for i, section := range sections {
+		records[i] = []string{
+			section.Query,
+			section.Device,
+			strconv.Itoa(section.Position),
+			section.Type,
+			section.Heading,
+		}
+		records[i] = append(records[i], crawlMetaCells(section.CrawlMeta)...)
+	}
+	return encodeCSV(header, records)
+}
+
+// encodeCSV writes header and records as gzip CSV
+func encodeCSV(header []string, records [][]string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	gzWriter := gzip.NewWriter(buffer)
+	csvWriter := csv.NewWriter(gzWriter)
+
+	if err := csvWriter.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	if err := csvWriter.WriteAll(records); err != nil {
+		return nil, fmt.Errorf("failed to write CSV record: %w", err)
+	}
+
+	if err := gzWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// jsonCell encodes a list extension as a JSON array for a CSV cell; an empty list is an empty cell
+func jsonCell[T any](values []T) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// timeCell formats a crawl time for a CSV cell
+func timeCell(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
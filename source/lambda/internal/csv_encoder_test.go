@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"slices"
+	"testing"
+)
+
+func TestCSVAdsKeepColumnPositions(t *testing.T) {
+	data, err := CSVEncoder{}.EncodeAds([]SearchResult{{Query: "꽃배달", Device: DeviceDesktop, Rank: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(gzReader).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Consumers of the data/ table read columns by position: the original
+	// columns stay first and new ones are only ever appended
+	original := []string{"query", "device", "rank", "site_name", "display_url", "title", "description"}
+	if header := records[0]; !slices.Equal(header[:len(original)], original) {
+		t.Errorf("got header %v, want it to start with %v", header, original)
+	}
+	if header := records[0]; !slices.Equal(header[len(header)-len(crawlMetaHeader):], crawlMetaHeader) {
+		t.Errorf("got header %v, want it to end with %v", header, crawlMetaHeader)
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// FailureReason classifies why a message was dead-lettered
+type FailureReason string
+
+const (
+	// ReasonParseError means the message body is not a valid SearchRequest
+	ReasonParseError FailureReason = "parse_error"
+
+	// ReasonInvalidRequest means the request is well-formed but cannot be crawled
+	ReasonInvalidRequest FailureReason = "invalid_request"
+
+	// ReasonLayoutChanged means the result page no longer matches our selectors
+	ReasonLayoutChanged FailureReason = "layout_changed"
+
+	// ReasonBlocked means Naver refused the request (403 or captcha page)
+	ReasonBlocked FailureReason = "blocked"
+
+	// ReasonPermanentHTTP means any other non-retryable HTTP status
+	ReasonPermanentHTTP FailureReason = "permanent_http"
+
+	// ReasonMaxReceives means transient failures persisted past Config.MaxReceiveCount attempts
+	ReasonMaxReceives FailureReason = "max_receives"
+)
+
+// DeadLetter is the body sent to the dead-letter queue
+type DeadLetter struct {
+	Reason       FailureReason `json:"reason"`
+	Detail       string        `json:"detail"`
+	Device       string        `json:"device,omitempty"`
+	MessageID    string        `json:"message_id"`
+	ReceiveCount int           `json:"receive_count"`
+	OriginalBody string        `json:"original_body"`
+	FailedAt     time.Time     `json:"failed_at"`
+}
+
+// classifyFailure returns the dead-letter reason for a permanent scrape
+// failure, or false when the error is transient and may be retried. A crawl
+// that used up the keyword's retry budget failed transiently, so it is
+// retried like any transient failure, within Config.MaxReceiveCount.
+func classifyFailure(err error) (FailureReason, bool) {
+	switch {
+	case errors.Is(err, ErrLayoutChanged):
+		return ReasonLayoutChanged, true
+	case errors.Is(err, ErrBlocked):
+		return ReasonBlocked, true
+	case IsRetryable(err):
+		return "", false
+	}
+
+	var scrapeErr *ScrapeError
+	if errors.As(err, &scrapeErr) {
+		return ReasonPermanentHTTP, true
+	}
+	return "", false
+}
+
+// sendDeadLetter records a message that will not be retried. It returns false
+// if the dead letter could not be sent, in which case the message must be
+// kept on the queue so nothing is lost.
+func (p *Processor) sendDeadLetter(ctx context.Context, message Message, attempts int, reason FailureReason, device string, cause error) bool {
+	letter := DeadLetter{
+		Reason:       reason,
+		Device:       device,
+		MessageID:    message.ID,
+		ReceiveCount: attempts,
+		OriginalBody: message.Body,
+		FailedAt:     time.Now().UTC(),
+	}
+	if cause != nil {
+		letter.Detail = cause.Error()
+	}
+
+	body, err := json.Marshal(letter)
+	if err != nil {
+		log.Printf("😡 [ERROR] Failed to encode dead letter: %v", err)
+		return false
+	}
+
+	if p.deps.DeadLetters == nil {
+		log.Printf("☠️ [DEAD LETTER] %s", body)
+		emitMetric("DeadLettered", 1, UnitCount, map[string]string{"Reason": string(reason)})
+		return true
+	}
+
+	if err := p.deps.DeadLetters.Send(ctx, string(body)); err != nil {
+		log.Printf("😡 [ERROR] Failed to send dead letter for %s: %v", letter.MessageID, err)
+		return false
+	}
+
+	log.Printf("☠️ Dead-lettered message %s (%s): %s", letter.MessageID, reason, letter.Detail)
+	emitMetric("DeadLettered", 1, UnitCount, map[string]string{"Reason": string(reason)})
+	return true
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// uploadedAds decodes every CSV ad object the sink uploaded and counts the
+// rows per query and device, as "query/device"
+func uploadedAds(t *testing.T, client *memS3) map[string]int {
+	t.Helper()
+	counts := map[string]int{}
+	for _, key := range client.keys(resultPrefix) {
+		gzReader, err := gzip.NewReader(bytes.NewReader(client.objects[key]))
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		records, err := csv.NewReader(gzReader).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		query, device := slices.Index(records[0], "query"), slices.Index(records[0], "device")
+		for _, record := range records[1:] {
+			counts[record[query]+"/"+record[device]]++
+		}
+	}
+	return counts
+}
+
+// deadLetterReasons returns the reason of every dead letter, keyed by the original body
+func deadLetterReasons(t *testing.T, queue *MemoryQueue) map[string]FailureReason {
+	t.Helper()
+	reasons := map[string]FailureReason{}
+	for _, body := range queue.Visible() {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(body), &letter); err != nil {
+			t.Fatal(err)
+		}
+		reasons[letter.OriginalBody] = letter.Reason
+	}
+	return reasons
+}
+
+func TestRunEndToEnd(t *testing.T) {
+	fake := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer fake.Close()
+	pointProfilesAt(t, fake, fake)
+	fake.Set("multi_part_title", FakeBehavior{Status: http.StatusServiceUnavailable, Times: 1})
+	fake.Set("blocked", FakeBehavior{Fixture: "captcha"})
+
+	bodies := []string{
+		`{"keyword":"ads_present"}`,
+		`{"keyword":"multi_part_title","device":"PC"}`,
+		`{"keyword":"blocked","device":"MO"}`,
+		`not json`,
+	}
+	queue := NewMemoryQueue(bodies...)
+	queue.BatchSize = 2
+	deadLetters := NewMemoryQueue()
+	client := newMemS3()
+	cfg := Config{
+		MaxReceiveCount: 5,
+		Retry:           fastRetry,
+		Invocation:      InvocationSettings{BatchSize: 2, Concurrency: 2, SafetyMargin: time.Second},
+	}
+	processor := NewProcessor(cfg, Dependencies{Queue: queue, DeadLetters: deadLetters, Sink: NewS3ResultSink(client, "bucket")})
+
+	stats, err := processor.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Received != 4 || stats.Finished != 4 || stats.Released != 0 {
+		t.Errorf("got %+v, want 4 messages received and finished", stats)
+	}
+
+	// Every message is deleted once its results are uploaded or it is dead-lettered
+	deleted := messageIDs(queue.Deleted())
+	if len(deleted) != 4 || len(queue.Visible()) != 0 || queue.InFlight() != 0 {
+		t.Errorf("got %v deleted, %d visible and %d in flight, want all 4 deleted", deleted, len(queue.Visible()), queue.InFlight())
+	}
+
+	// The ads of both devices, and of the keyword whose first request failed
+	want := map[string]int{"ads_present/PC": 3, "ads_present/MO": 2, "multi_part_title/PC": 2}
+	if got := uploadedAds(t, client); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got uploaded ads %v, want %v", got, want)
+	}
+	if n := fake.Requests("multi_part_title"); n != 2 {
+		t.Errorf("got %d requests for the keyword failing once, want 2", n)
+	}
+
+	wantReasons := map[string]FailureReason{bodies[2]: ReasonBlocked, bodies[3]: ReasonParseError}
+	if got := deadLetterReasons(t, deadLetters); fmt.Sprint(got) != fmt.Sprint(wantReasons) {
+		t.Errorf("got dead letters %v, want %v", got, wantReasons)
+	}
+}
+
+// eventQueueURL and eventSourceARN address the same queue
+const (
+	eventQueueURL  = "https://sqs.ap-northeast-2.amazonaws.com/123456789012/keywords"
+	eventSourceARN = "arn:aws:sqs:ap-northeast-2:123456789012:keywords"
+)
+
+func TestHandleSQSEventEndToEnd(t *testing.T) {
+	desktop := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer desktop.Close()
+	mobile := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer mobile.Close()
+	pointProfilesAt(t, desktop, mobile)
+	mobile.Set("ads_present", FakeBehavior{Status: http.StatusServiceUnavailable})
+	mobile.Set("unavailable", FakeBehavior{Status: http.StatusServiceUnavailable})
+
+	// An SQS event batch as the event source mapping delivers it
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "m1", Body: `{"keyword":"multi_part_title"}`, EventSourceARN: eventSourceARN},
+		{MessageId: "m2", Body: `{"keyword":"ads_present"}`, EventSourceARN: eventSourceARN},
+		{MessageId: "m3", Body: `{"keyword":"unavailable","device":"MO"}`, EventSourceARN: eventSourceARN},
+	}}
+
+	queue := NewMemoryQueue()
+	client := newMemS3()
+	cfg := Config{QueueURL: eventQueueURL, MaxReceiveCount: 5, Retry: fastRetry, Invocation: InvocationSettings{Concurrency: 2}}
+	processor := NewProcessor(cfg, Dependencies{Queue: queue, Sink: NewS3ResultSink(client, "bucket")})
+
+	response, err := processor.HandleSQSEvent(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// m2 keeps its desktop results and requeues the mobile crawl; m3 has
+	// nothing to keep and is reported for SQS to redeliver
+	if want := []events.SQSBatchItemFailure{{ItemIdentifier: "m3"}}; !slices.Equal(response.BatchItemFailures, want) {
+		t.Errorf("got batch item failures %v, want %v", response.BatchItemFailures, want)
+	}
+	want := map[string]int{"multi_part_title/PC": 2, "multi_part_title/MO": 1, "ads_present/PC": 3}
+	if got := uploadedAds(t, client); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got uploaded ads %v, want %v", got, want)
+	}
+
+	requeued := queue.Visible()
+	if len(requeued) != 1 {
+		t.Fatalf("got %d requeued messages, want 1", len(requeued))
+	}
+	var request SearchRequest
+	if err := json.Unmarshal([]byte(requeued[0]), &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Keyword != "ads_present" || request.Device != DeviceMobile || request.Retries != 1 {
+		t.Errorf("got requeued request %+v, want ads_present on MO after 1 attempt", request)
+	}
+	if n := mobile.Requests("unavailable"); n != fastRetry.MaxAttempts {
+		t.Errorf("got %d requests, want %d", n, fastRetry.MaxAttempts)
+	}
+	if len(queue.Deleted()) != 0 {
+		t.Errorf("got %d messages deleted, want them left to the event source mapping", len(queue.Deleted()))
+	}
+}
+
+func TestHandleSQSEventRejectsOtherQueues(t *testing.T) {
+	fake := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer fake.Close()
+	pointProfilesAt(t, fake, fake)
+
+	// Retries of this batch would be sent to the configured queue instead
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "m1", Body: `{"keyword":"ads_present"}`, EventSourceARN: eventSourceARN},
+		{MessageId: "m2", Body: `{"keyword":"ads_present"}`, EventSourceARN: "arn:aws:sqs:ap-northeast-2:123456789012:other"},
+	}}
+	cfg := Config{QueueURL: eventQueueURL, MaxReceiveCount: 5, Retry: fastRetry, Invocation: InvocationSettings{Concurrency: 2}}
+	sink := &MemorySink{}
+	processor := NewProcessor(cfg, Dependencies{Queue: NewMemoryQueue(), Sink: sink})
+
+	if _, err := processor.HandleSQSEvent(context.Background(), event); err == nil {
+		t.Fatal("got no error for a batch from another queue")
+	}
+	if n := fake.Requests("ads_present"); n != 0 || sink.Writes() != 0 {
+		t.Errorf("got %d requests and %d writes, want the batch left alone", n, sink.Writes())
+	}
+}
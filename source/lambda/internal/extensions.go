@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// redirectParams are the query parameters Naver's ad click redirects carry the
+// advertiser's landing URL in
+var redirectParams = []string{"u", "url", "lu", "target"}
+
+// landingURL returns the click-through URL of an ad anchor. Naver ad links go
+// through a redirect on a naver.com host; when the landing URL is carried in
+// its query it is returned instead of the redirect. Empty and fragment-only
+// hrefs yield "".
+func landingURL(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "javascript:") {
+		return ""
+	}
+
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != "naver.com" && !strings.HasSuffix(host, ".naver.com") {
+		return href
+	}
+
+	query := u.Query()
+	for _, param := range redirectParams {
+		target, err := url.Parse(query.Get(param))
+		if err == nil && (target.Scheme == "http" || target.Scheme == "https") && target.Host != "" {
+			return target.String()
+		}
+	}
+	return href
+}
+
+// adBlock returns the name of the first block whose container is an ancestor of item
+func adBlock(item *goquery.Selection, blocks []BlockSelector) string {
+	for _, block := range blocks {
+		if item.Closest(block.Container).Length() > 0 {
+			return block.Name
+		}
+	}
+	return AdBlockPowerLinkTop
+}
+
+// adRanks numbers ads per rank sequence. Brand search ads are ranked in a
+// sequence of their own so they do not shift the power link ranks; every
+// other block shares the power link sequence.
+type adRanks map[string]int
+
+// next returns the rank of the next ad of block
+func (r adRanks) next(block string) int {
+	sequence := ""
+	if block == AdBlockBrandSearch {
+		sequence = AdBlockBrandSearch
+	}
+	r[sequence]++
+	return r[sequence]
+}
+
+// extractExtensions collects the extensions of one ad item
+func extractExtensions(item *goquery.Selection, sel ExtensionSelectors) AdExtensions {
+	var ext AdExtensions
+
+	if sel.Sitelink != "" {
+		item.Find(sel.Sitelink).Each(func(_ int, link *goquery.Selection) {
+			title := strings.TrimSpace(link.Text())
+			if title == "" {
+				return
+			}
+			href, _ := link.Attr("href")
+			ext.Sitelinks = append(ext.Sitelinks, Sitelink{Title: title, URL: landingURL(href)})
+		})
+	}
+
+	ext.Phone = phoneNumber(item, sel.Phone)
+	ext.Price = firstText(item, sel.Price)
+	ext.Promotion = firstText(item, sel.Promotion)
+
+	if sel.Thumbnail != "" {
+		item.Find(sel.Thumbnail).Each(func(_ int, img *goquery.Selection) {
+			// Lazy-loaded images keep the real source in data-src
+			src, ok := img.Attr("data-src")
+			if !ok || strings.TrimSpace(src) == "" {
+				src, _ = img.Attr("src")
+			}
+			if src = strings.TrimSpace(src); src != "" {
+				ext.Thumbnails = append(ext.Thumbnails, src)
+			}
+		})
+	}
+	return ext
+}
+
+// phoneNumber returns the displayed number, or the number of the tel: link
+// when the text has none (mobile pages label it with an icon or "전화")
+func phoneNumber(item *goquery.Selection, selector string) string {
+	if selector == "" {
+		return ""
+	}
+	text := firstText(item, selector)
+	if strings.ContainsAny(text, "0123456789") {
+		return text
+	}
+	if href, ok := item.Find(selector).First().Attr("href"); ok && strings.HasPrefix(href, "tel:") {
+		return strings.TrimPrefix(href, "tel:")
+	}
+	return text
+}
+
+// firstText returns the trimmed text of the first match of selector in item
+func firstText(item *goquery.Selection, selector string) string {
+	if selector == "" {
+		return ""
+	}
+	return strings.Join(strings.Fields(item.Find(selector).First().Text()), " ")
+}
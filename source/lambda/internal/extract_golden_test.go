@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Regenerate the golden files after an intentional selector change with:
+//
+//	go test ./internal -run TestExtractGolden -update
+var update = flag.Bool("update", false, "rewrite golden files under testdata/serp")
+
+// goldenKeyword is the query every fixture is extracted with
+const goldenKeyword = "테스트"
+
+// goldenMeta is the crawl metadata every fixture is stamped with
+var goldenMeta = CrawlMeta{
+	CrawledAt:  time.Date(2025, 8, 13, 0, 0, 0, 0, time.UTC),
+	RequestID:  "golden",
+	UserAgent:  "golden-agent",
+	HTTPStatus: 200,
+}
+
+// goldenOutput is the expected extraction of one fixture page
+type goldenOutput struct {
+	PageResult
+	Error string `json:"error,omitempty"`
+}
+
+// TestExtractGolden runs the extractor for each device over the saved result
+// pages in testdata/serp/<device>/*.html and compares the output with the
+// matching .golden.json file. Ad list pages (*_adlistN.html) are extracted
+// with the device's ad list selectors.
+func TestExtractGolden(t *testing.T) {
+	config, err := ParseSelectorConfig(embeddedSelectorConfig)
+	if err != nil {
+		t.Fatalf("embedded selector config: %v", err)
+	}
+
+	for device, sel := range config.Devices {
+		pages, err := filepath.Glob(filepath.Join("testdata", "serp", strings.ToLower(device), "*.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) == 0 {
+			t.Errorf("no fixtures for device %s", device)
+		}
+
+		for _, page := range pages {
+			name := strings.TrimSuffix(filepath.Base(page), ".html")
+			pageSel := sel
+			if strings.Contains(name, AdListFixtureSuffix) {
+				pageSel = sel.adListSelectors()
+			}
+			t.Run(device+"/"+name, func(t *testing.T) {
+				got := extractFixture(t, page, device, pageSel)
+				golden := strings.TrimSuffix(page, ".html") + ".golden.json"
+
+				if *update {
+					if err := os.WriteFile(golden, got, 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v (run with -update to create it)", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("extraction of %s does not match %s\n--- got\n%s\n--- want\n%s", page, golden, got, want)
+				}
+			})
+		}
+	}
+}
+
+// extractFixture parses a fixture page and returns its extraction as indented JSON
+func extractFixture(t *testing.T, page, device string, sel SelectorSet) []byte {
+	t.Helper()
+
+	f, err := os.Open(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extracted, err := extractPage(doc, goldenKeyword, device, sel)
+	extracted.stamp(goldenMeta)
+	out := goldenOutput{PageResult: extracted}
+	if out.Ads == nil {
+		out.Ads = []SearchResult{}
+	}
+	if out.Organic == nil {
+		out.Organic = []OrganicResult{}
+	}
+	if out.Sections == nil {
+		out.Sections = []SerpSection{}
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFakeFixture is the fixture FakeNaver serves for queries without a fixture of their own
+const DefaultFakeFixture = "no_ads"
+
+// AdListFixtureSuffix marks ad list fixtures: page N of the ad list for
+// query q is served from <q>_adlistN.html
+const AdListFixtureSuffix = "_adlist"
+
+// FakeBehavior changes how FakeNaver answers one query
+type FakeBehavior struct {
+	// Fixture is served instead of the fixture named after the query
+	// (e.g. "captcha" to serve a captcha page)
+	Fixture string
+
+	// Status, when not 200, is returned instead of a page
+	Status int
+
+	// RetryAfter is sent as the Retry-After header of a non-200 response
+	RetryAfter string
+
+	// Delay is waited before answering, e.g. to outlast the client timeout
+	Delay time.Duration
+
+	// Times limits Status and Delay to the first requests for the query;
+	// later requests get the page, Fixture if set. Zero applies them to
+	// every request.
+	Times int
+}
+
+// FakeNaver is a local stand-in for the Naver search pages, for integration
+// tests and local runs. It serves testdata/serp-style fixtures: a request for
+// query q is answered with <dir>/<device>/<q>.html, where the device is "mo"
+// for where=m... requests and "pc" otherwise. Requests with a pagingIndex are
+// ad list pages and get <q>_adlist<pagingIndex>.html, or a 404 when there is
+// none; fixtures should link to them with relative URLs. Point the scrapers
+// at it with SetSearchBaseURL or the *_SEARCH_BASE_URL variables.
+type FakeNaver struct {
+	*httptest.Server
+
+	dir string
+
+	mu        sync.Mutex
+	behaviors map[string]FakeBehavior
+	requests  map[string]int
+}
+
+// NewFakeNaver starts a server serving the fixtures under dir. Close it when done.
+func NewFakeNaver(dir string) *FakeNaver {
+	f := &FakeNaver{
+		dir:       dir,
+		behaviors: map[string]FakeBehavior{},
+		requests:  map[string]int{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Set configures the behavior for a query, replacing any earlier one
+func (f *FakeNaver) Set(query string, behavior FakeBehavior) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.behaviors[query] = behavior
+}
+
+// Requests returns how many requests were made for a query
+func (f *FakeNaver) Requests(query string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[query]
+}
+
+func (f *FakeNaver) serve(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	device := "pc"
+	if strings.HasPrefix(r.URL.Query().Get("where"), "m") {
+		device = "mo"
+	}
+
+	f.mu.Lock()
+	f.requests[query]++
+	behavior, ok := f.behaviors[query]
+	failing := ok && (behavior.Times == 0 || f.requests[query] <= behavior.Times)
+	f.mu.Unlock()
+
+	fixture := query
+	if ok && behavior.Fixture != "" {
+		fixture = behavior.Fixture
+	}
+	if failing {
+		if behavior.Delay > 0 {
+			select {
+			case <-time.After(behavior.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if behavior.Status != 0 && behavior.Status != http.StatusOK {
+			if behavior.RetryAfter != "" {
+				w.Header().Set("Retry-After", behavior.RetryAfter)
+			}
+			http.Error(w, http.StatusText(behavior.Status), behavior.Status)
+			return
+		}
+	}
+
+	var page []byte
+	var err error
+	if index := r.URL.Query().Get("pagingIndex"); index != "" {
+		if _, convErr := strconv.Atoi(index); convErr != nil {
+			http.Error(w, "invalid pagingIndex", http.StatusBadRequest)
+			return
+		}
+		page, err = f.readFixture(device, fixture+AdListFixtureSuffix+index)
+	} else {
+		page, err = f.fixture(device, fixture)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	w.Write(page)
+}
+
+// fixture reads <dir>/<device>/<name>.html, falling back to DefaultFakeFixture
+func (f *FakeNaver) fixture(device, name string) ([]byte, error) {
+	page, err := f.readFixture(device, name)
+	if os.IsNotExist(err) && name != DefaultFakeFixture {
+		return f.fixture(device, DefaultFakeFixture)
+	}
+	return page, err
+}
+
+// readFixture reads <dir>/<device>/<name>.html
+func (f *FakeNaver) readFixture(device, name string) ([]byte, error) {
+	// Queries are user input; keep them inside the fixture directory
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		name = DefaultFakeFixture
+	}
+	return os.ReadFile(filepath.Join(f.dir, device, name+".html"))
+}
@@ -0,0 +1,315 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fastRetry keeps retry tests quick
+var fastRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, KeywordBudget: 5}
+
+// fakeScraper returns a scraper for profile pointed at the fake server
+func fakeScraper(t *testing.T, fake *FakeNaver, profile DeviceProfile) Scraper {
+	t.Helper()
+	searchURL, err := withBaseURL(profile.SearchURL, fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.SearchURL = searchURL
+	profile.Client = &http.Client{Timeout: 200 * time.Millisecond}
+	return NewScraper(profile)
+}
+
+func TestFakeNaverServesFixturesByQuery(t *testing.T) {
+	fake := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer fake.Close()
+	ctx := WithRetryPolicy(context.Background(), fastRetry)
+
+	for _, profile := range []DeviceProfile{DesktopProfile, MobileProfile} {
+		page, err := fakeScraper(t, fake, profile).Scrape(ctx, "ads_present")
+		if err != nil {
+			t.Fatalf("%s: %v", profile.Device, err)
+		}
+		results := page.Ads
+		if len(results) == 0 || results[0].Device != profile.Device || results[0].Query != "ads_present" {
+			t.Errorf("%s: unexpected results %+v", profile.Device, results)
+		}
+
+		// Queries without a fixture get the no-ads page
+		page, err = fakeScraper(t, fake, profile).Scrape(ctx, "아무 키워드")
+		if err != nil || len(page.Ads) != 0 {
+			t.Errorf("%s: got %d results, %v; want none", profile.Device, len(page.Ads), err)
+		}
+	}
+}
+
+func TestFakeNaverFailures(t *testing.T) {
+	fake := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer fake.Close()
+	ctx := WithRetryPolicy(context.Background(), fastRetry)
+	scraper := fakeScraper(t, fake, DesktopProfile)
+
+	t.Run("unavailable then recovers", func(t *testing.T) {
+		// The fixture is served once the failures are over
+		fake.Set("recovering", FakeBehavior{Status: http.StatusServiceUnavailable, Times: 2, Fixture: "ads_present"})
+
+		page, err := scraper.Scrape(ctx, "recovering")
+		if err != nil || len(page.Ads) == 0 {
+			t.Fatalf("got %d results, %v; want results after retries", len(page.Ads), err)
+		}
+		if n := fake.Requests("recovering"); n != 3 {
+			t.Errorf("got %d requests, want 3", n)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		fake.Set("limited", FakeBehavior{Status: http.StatusTooManyRequests, RetryAfter: "0"})
+
+		_, err := scraper.Scrape(ctx, "limited")
+		var scrapeErr *ScrapeError
+		if !errors.As(err, &scrapeErr) || scrapeErr.StatusCode != http.StatusTooManyRequests || !IsRetryable(err) {
+			t.Fatalf("got %v, want a retryable 429", err)
+		}
+		if n := fake.Requests("limited"); n != fastRetry.MaxAttempts {
+			t.Errorf("got %d requests, want %d", n, fastRetry.MaxAttempts)
+		}
+	})
+
+	t.Run("slow", func(t *testing.T) {
+		fake.Set("slow", FakeBehavior{Delay: time.Second})
+
+		_, err := scraper.Scrape(ctx, "slow")
+		if err == nil || !IsRetryable(err) {
+			t.Fatalf("got %v, want a retryable timeout", err)
+		}
+	})
+
+	t.Run("captcha", func(t *testing.T) {
+		fake.Set("blocked", FakeBehavior{Fixture: "captcha"})
+
+		_, err := scraper.Scrape(ctx, "blocked")
+		if !errors.Is(err, ErrBlocked) {
+			t.Fatalf("got %v, want ErrBlocked", err)
+		}
+		if n := fake.Requests("blocked"); n != 1 {
+			t.Errorf("got %d requests, want 1 (captcha pages are not retried)", n)
+		}
+	})
+}
+
+func TestProcessMessageAgainstFakeNaver(t *testing.T) {
+	fake := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer fake.Close()
+
+	for _, profile := range []DeviceProfile{DesktopProfile, MobileProfile} {
+		if err := SetSearchBaseURL(profile.Device, fake.URL); err != nil {
+			t.Fatal(err)
+		}
+		defer RegisterProfile(profile)
+	}
+	fake.Set("layout_changed", FakeBehavior{Fixture: "layout_changed"})
+
+	queue := NewMemoryQueue(
+		`{"keyword":"ads_present"}`,
+		`{"keyword":"layout_changed","device":"MO"}`,
+	)
+	sink := &MemorySink{}
+	archive := &MemoryArchive{}
+	cfg := Config{MaxReceiveCount: 5, Retry: fastRetry}
+	processor := NewProcessor(cfg, Dependencies{Queue: queue, Sink: sink, Archive: archive})
+
+	ctx := context.Background()
+	messages, err := processor.ReceiveMessages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if !processor.ProcessMessage(ctx, m) {
+			t.Errorf("message %s was not settled", m.Body)
+		}
+	}
+
+	// Without batch limits the results stay buffered, and so the message
+	// holding them, until the flush; the drifted message has no results
+	if durable := processor.CommitMessages(ctx, messages); len(durable) != 1 || durable[0].ID != messages[1].ID {
+		t.Errorf("got %v committed, want only the drifted message", durable)
+	}
+	if sink.Writes() != 0 {
+		t.Errorf("got %d writes before the flush", sink.Writes())
+	}
+	if durable := processor.FlushResults(ctx); len(durable) != 1 || durable[0].ID != messages[0].ID {
+		t.Errorf("got %v flushed, want the crawled message", durable)
+	}
+
+	// 3 desktop and 2 mobile ads in one write per device; the drifted page is
+	// dead-lettered without results
+	if got := len(sink.Results()); got != 5 {
+		t.Errorf("got %d stored results, want 5", got)
+	}
+	if got := sink.Writes(); got != 2 {
+		t.Errorf("got %d writes, want 2", got)
+	}
+
+	// Every fetched page is archived, including the drifted one
+	if got := len(archive.Pages()); got != 3 {
+		t.Errorf("got %d archived pages, want 3", got)
+	}
+}
+
+// pointProfilesAt points the desktop profile at desktop and the mobile one
+// at mobile until the test ends
+func pointProfilesAt(t *testing.T, desktop, mobile *FakeNaver) {
+	t.Helper()
+	for _, target := range []struct {
+		profile DeviceProfile
+		fake    *FakeNaver
+	}{{DesktopProfile, desktop}, {MobileProfile, mobile}} {
+		if err := SetSearchBaseURL(target.profile.Device, target.fake.URL); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { RegisterProfile(target.profile) })
+	}
+}
+
+// unsendableQueue is a MemoryQueue whose sends fail
+type unsendableQueue struct {
+	*MemoryQueue
+}
+
+func (q *unsendableQueue) Send(context.Context, string) error {
+	return errors.New("sqs down")
+}
+
+func TestFailedRequeueBuffersNothing(t *testing.T) {
+	desktop := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer desktop.Close()
+	mobile := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer mobile.Close()
+	mobile.Set("ads_present", FakeBehavior{Status: http.StatusServiceUnavailable})
+
+	pointProfilesAt(t, desktop, mobile)
+
+	queue := &unsendableQueue{NewMemoryQueue(`{"keyword":"ads_present"}`)}
+	sink := &MemorySink{}
+	processor := NewProcessor(Config{MaxReceiveCount: 5, Retry: fastRetry}, Dependencies{Queue: queue, Sink: sink})
+
+	ctx := context.Background()
+	messages, err := processor.ReceiveMessages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The desktop crawl succeeds but the mobile one cannot be requeued, so
+	// the whole message is redelivered and its desktop results crawled again
+	if processor.ProcessMessage(ctx, messages[0]) {
+		t.Fatal("message finished although its failed device was not requeued")
+	}
+	if durable := processor.FlushResults(ctx); len(durable) != 0 || sink.Writes() != 0 {
+		t.Errorf("got %d messages and %d writes flushed, want nothing buffered", len(durable), sink.Writes())
+	}
+}
+
+func TestRetryBudgetExhaustionIsTransient(t *testing.T) {
+	desktop := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer desktop.Close()
+	mobile := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer mobile.Close()
+	pointProfilesAt(t, desktop, mobile)
+	desktop.Set("ads_present", FakeBehavior{Status: http.StatusServiceUnavailable, Times: 1})
+	desktop.Set("last_try", FakeBehavior{Status: http.StatusServiceUnavailable, Times: 1, Fixture: "ads_present"})
+	mobile.Set("ads_present", FakeBehavior{Status: http.StatusServiceUnavailable})
+	mobile.Set("last_try", FakeBehavior{Status: http.StatusServiceUnavailable})
+
+	// The desktop retry uses up the budget, so the mobile 503 is not retried.
+	// The second message has already been tried MaxReceiveCount-1 times.
+	queue := NewMemoryQueue(`{"keyword":"ads_present"}`, `{"keyword":"last_try","retries":4}`)
+	deadLetters := NewMemoryQueue()
+	retry := fastRetry
+	retry.KeywordBudget = 1
+	processor := NewProcessor(Config{MaxReceiveCount: 5, Retry: retry}, Dependencies{Queue: queue, DeadLetters: deadLetters, Sink: &MemorySink{}})
+
+	ctx := context.Background()
+	messages, err := processor.ReceiveMessages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if !processor.ProcessMessage(ctx, m) {
+			t.Fatalf("message %s was not settled", m.Body)
+		}
+	}
+	if n := mobile.Requests("ads_present"); n != 1 {
+		t.Errorf("got %d mobile requests, want 1 with the budget used up", n)
+	}
+
+	// The first keyword's mobile crawl is requeued, not dead-lettered
+	requeued := queue.Visible()
+	if len(requeued) != 1 {
+		t.Fatalf("got %d requeued messages, want 1", len(requeued))
+	}
+	var request SearchRequest
+	if err := json.Unmarshal([]byte(requeued[0]), &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Keyword != "ads_present" || request.Device != DeviceMobile {
+		t.Errorf("got requeued request %+v, want ads_present on MO", request)
+	}
+
+	// The second one reached the receive cap and is dead-lettered with the 503
+	letters := deadLetters.Visible()
+	if len(letters) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(letters))
+	}
+	var letter DeadLetter
+	if err := json.Unmarshal([]byte(letters[0]), &letter); err != nil {
+		t.Fatal(err)
+	}
+	if letter.Reason != ReasonMaxReceives || letter.Device != DeviceMobile || !strings.Contains(letter.Detail, "503") {
+		t.Errorf("got %+v, want %q for MO with the 503", letter, ReasonMaxReceives)
+	}
+}
+
+func TestRequeueCarriesAttempts(t *testing.T) {
+	desktop := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer desktop.Close()
+	mobile := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer mobile.Close()
+	mobile.Set("ads_present", FakeBehavior{Status: http.StatusServiceUnavailable})
+	pointProfilesAt(t, desktop, mobile)
+
+	queue := NewMemoryQueue(`{"keyword":"ads_present"}`)
+	processor := NewProcessor(Config{MaxReceiveCount: 5, Retry: fastRetry}, Dependencies{Queue: queue, Sink: &MemorySink{}})
+
+	// The third delivery of the message
+	ctx := context.Background()
+	var messages []Message
+	for range 3 {
+		queue.ExpireInFlight()
+		var err error
+		if messages, err = processor.ReceiveMessages(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !processor.ProcessMessage(ctx, messages[0]) {
+		t.Fatal("message was not finished")
+	}
+
+	// The requeued mobile crawl starts from the 3 attempts already made
+	requeued := queue.Visible()
+	if len(requeued) != 1 {
+		t.Fatalf("got %d requeued messages, want 1", len(requeued))
+	}
+	var request SearchRequest
+	if err := json.Unmarshal([]byte(requeued[0]), &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Device != DeviceMobile || request.Retries != 3 {
+		t.Errorf("got requeued request %+v, want MO with 3 retries", request)
+	}
+}
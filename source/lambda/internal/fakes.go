@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is an in-memory Queue for offline tests and local runs.
+// Received messages stay in flight until deleted, made visible again with a
+// zero ExtendVisibility, or released with ExpireInFlight.
+type MemoryQueue struct {
+	mu       sync.Mutex
+	nextID   int
+	visible  []Message
+	inFlight map[string]Message
+	deleted  []Message
+	counts   map[string]int
+
+	// BatchSize is the most messages one Receive returns (default 10)
+	BatchSize int
+}
+
+// NewMemoryQueue returns a queue holding one message per body
+func NewMemoryQueue(bodies ...string) *MemoryQueue {
+	q := &MemoryQueue{
+		inFlight:  map[string]Message{},
+		counts:    map[string]int{},
+		BatchSize: 10,
+	}
+	for _, body := range bodies {
+		q.push(body)
+	}
+	return q
+}
+
+func (q *MemoryQueue) push(body string) {
+	q.nextID++
+	q.visible = append(q.visible, Message{ID: fmt.Sprintf("msg-%d", q.nextID), Body: body})
+}
+
+// Receive moves up to BatchSize visible messages in flight
+func (q *MemoryQueue) Receive(ctx context.Context) ([]Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := min(max(q.BatchSize, 1), len(q.visible))
+	batch := make([]Message, 0, n)
+	for _, m := range q.visible[:n] {
+		q.counts[m.ID]++
+		m.ReceiveCount = q.counts[m.ID]
+		m.ReceiptHandle = fmt.Sprintf("%s#%d", m.ID, m.ReceiveCount)
+		q.inFlight[m.ReceiptHandle] = m
+		batch = append(batch, m)
+	}
+	q.visible = q.visible[n:]
+	return batch, nil
+}
+
+// Delete removes in-flight messages; unknown receipt handles are returned as failed
+func (q *MemoryQueue) Delete(_ context.Context, messages []Message) []Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var failed []Message
+	for _, m := range messages {
+		if _, ok := q.inFlight[m.ReceiptHandle]; !ok {
+			failed = append(failed, m)
+			continue
+		}
+		delete(q.inFlight, m.ReceiptHandle)
+		q.deleted = append(q.deleted, m)
+	}
+	return failed
+}
+
+// ExtendVisibility keeps an in-flight message hidden; a zero timeout makes it visible again
+func (q *MemoryQueue) ExtendVisibility(_ context.Context, message Message, timeout time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	m, ok := q.inFlight[message.ReceiptHandle]
+	if !ok {
+		return fmt.Errorf("message %s is not in flight", message.ID)
+	}
+	if timeout == 0 {
+		delete(q.inFlight, message.ReceiptHandle)
+		q.visible = append(q.visible, m)
+	}
+	return nil
+}
+
+// Send enqueues a new message
+func (q *MemoryQueue) Send(_ context.Context, body string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.push(body)
+	return nil
+}
+
+// ExpireInFlight makes every in-flight message visible again, as if its
+// visibility timeout had passed
+func (q *MemoryQueue) ExpireInFlight() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for handle, m := range q.inFlight {
+		delete(q.inFlight, handle)
+		q.visible = append(q.visible, m)
+	}
+}
+
+// Visible returns the bodies of messages waiting to be received
+func (q *MemoryQueue) Visible() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	bodies := make([]string, len(q.visible))
+	for i, m := range q.visible {
+		bodies[i] = m.Body
+	}
+	return bodies
+}
+
+// InFlight returns the number of received messages not yet deleted
+func (q *MemoryQueue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.inFlight)
+}
+
+// Deleted returns the messages deleted so far
+func (q *MemoryQueue) Deleted() []Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]Message(nil), q.deleted...)
+}
+
+// MemorySink is an in-memory ResultSink for offline tests and local runs
+type MemorySink struct {
+	mu    sync.Mutex
+	pages []PageResult
+	hours []time.Time
+
+	// Err, when set, is returned by WritePage instead of storing the page
+	Err error
+}
+
+// WritePage stores a copy of page
+func (s *MemorySink) WritePage(_ context.Context, hour time.Time, page PageResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.pages = append(s.pages, PageResult{
+		Ads:      append([]SearchResult(nil), page.Ads...),
+		Organic:  append([]OrganicResult(nil), page.Organic...),
+		Sections: append([]SerpSection(nil), page.Sections...),
+	})
+	s.hours = append(s.hours, hour)
+	return nil
+}
+
+// Results returns every stored ad in write order
+func (s *MemorySink) Results() []SearchResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []SearchResult
+	for _, page := range s.pages {
+		all = append(all, page.Ads...)
+	}
+	return all
+}
+
+// Pages returns every stored page in write order
+func (s *MemorySink) Pages() []PageResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PageResult(nil), s.pages...)
+}
+
+// Hours returns the partition hour of every stored page in write order
+func (s *MemorySink) Hours() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.hours...)
+}
+
+// Writes returns how many pages were stored
+func (s *MemorySink) Writes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pages)
+}
+
+// MemoryArchive is an in-memory PageArchive
+type MemoryArchive struct {
+	mu    sync.Mutex
+	pages []RawPage
+}
+
+// StorePage stores a copy of page
+func (a *MemoryArchive) StorePage(_ context.Context, page RawPage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	page.Body = append([]byte(nil), page.Body...)
+	a.pages = append(a.pages, page)
+	return nil
+}
+
+// Pages returns the stored pages in store order
+func (a *MemoryArchive) Pages() []RawPage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]RawPage(nil), a.pages...)
+}
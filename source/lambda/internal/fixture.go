@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// strippedElements carry no ad content but session state, visitor state or
+// tracking code, and are removed from captured pages
+const strippedElements = "script, style, noscript, iframe, link, meta:not([charset]), input[type=hidden]"
+
+// trackingParams are URL query parameters that identify a click, a session or
+// a visitor. They are dropped from every URL of a captured page; redirect
+// parameters carrying a landing URL are kept so extraction is unchanged.
+var trackingParams = []string{"NaPm", "nclick", "key", "token", "sid", "session", "sessionid"}
+
+// ipAddress matches IPv4 addresses, which the captcha page shows the visitor
+var ipAddress = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+
+// AnonymizePage strips a result page captured from Naver down to what the
+// extractor reads, so it can be committed as a fixture: scripts, styles,
+// comments, hidden inputs, event handlers and data attributes other than
+// data-src are removed, tracking parameters are dropped from URLs and IP
+// addresses are masked.
+func AnonymizePage(body []byte) ([]byte, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+
+	doc.Find(strippedElements).Remove()
+	doc.Find("*").AddSelection(doc.Selection).Contents().FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return goquery.NodeName(s) == "#comment"
+	}).Remove()
+
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		node := s.Get(0)
+		kept := node.Attr[:0]
+		for _, attr := range node.Attr {
+			name := strings.ToLower(attr.Key)
+			switch {
+			case strings.HasPrefix(name, "on"), strings.HasPrefix(name, "data-") && name != "data-src", name == "nonce", name == "integrity":
+				continue
+			case name == "href", name == "src", name == "data-src", name == "action":
+				attr.Val = stripTrackingParams(attr.Val)
+			}
+			kept = append(kept, attr)
+		}
+		node.Attr = kept
+	})
+
+	page, err := doc.Html()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render page: %w", err)
+	}
+	return []byte(ipAddress.ReplaceAllString(page, "0.0.0.0")), nil
+}
+
+// stripTrackingParams drops the tracking parameters and the fragment of a URL,
+// including those of a landing URL carried in a redirect parameter
+func stripTrackingParams(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.RawQuery == "" && u.Fragment == "") {
+		return raw
+	}
+	query := u.Query()
+	for _, param := range trackingParams {
+		query.Del(param)
+	}
+	for _, param := range redirectParams {
+		if target := query.Get(param); target != "" {
+			query.Set(param, stripTrackingParams(target))
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestAnonymizePage(t *testing.T) {
+	captured := `<!doctype html>
+<html><head><meta charset="utf-8"><meta name="referrer" content="always">
+<script nonce="abc">var g_puid = "visitor-123";</script><style>.x{}</style></head>
+<body><!-- served by web-42 -->
+<form action="/search?query=x&amp;sid=s3cr3t"><input type="hidden" name="token" value="t0k3n"></form>
+<ul class="lst_type"><li class="lst" data-cr-rank="1" onclick="nclk(this)">
+<a class="lnk_head" href="https://ader.naver.com/v1/click?x=1&amp;nclick=c1&amp;u=https%3A%2F%2Fshop.example%2F%3Fitem%3D7%26NaPm%3Dci%253D99">꽃배달</a>
+<img data-src="https://search.pstatic.net/thumb.jpg" data-gdid="g1">
+</li></ul>
+<p>접속 IP: 203.0.113.7</p>
+</body></html>`
+
+	page, err := AnonymizePage([]byte(captured))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(page)
+	for _, leaked := range []string{"visitor-123", "web-42", "s3cr3t", "t0k3n", "referrer", "nonce", "nclk", "data-cr-rank", "data-gdid", "nclick", "NaPm", "203.0.113.7", ".x{}"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("anonymized page still contains %q:\n%s", leaked, got)
+		}
+	}
+	for _, kept := range []string{`charset="utf-8"`, `class="lnk_head"`, `data-src="https://search.pstatic.net/thumb.jpg"`, "꽃배달", "0.0.0.0"} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("anonymized page lost %q:\n%s", kept, got)
+		}
+	}
+
+	// The landing URL is still decoded from the redirect, without its tracking parameter
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	href, _ := doc.Find("a.lnk_head").Attr("href")
+	if landing := landingURL(href); landing != "https://shop.example/?item=7" {
+		t.Errorf("got landing URL %q, want https://shop.example/?item=7", landing)
+	}
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// VisibilitySettings control how long received messages stay hidden from
+// other consumers. Messages are received with Timeout and, while they are
+// worked on, a heartbeat extends it to Timeout again every Interval, so a
+// slow crawl is not redelivered while a crashed invocation's messages still
+// come back quickly.
+type VisibilitySettings struct {
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+// heartbeats runs one visibility heartbeat per message being worked on
+type heartbeats struct {
+	queue    Queue
+	settings VisibilitySettings
+
+	mu      sync.Mutex
+	running map[string]*heartbeat // by receipt handle
+}
+
+// heartbeat is the running heartbeat of one message
+type heartbeat struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the goroutine has returned
+}
+
+func newHeartbeats(queue Queue, settings VisibilitySettings) *heartbeats {
+	return &heartbeats{queue: queue, settings: settings, running: map[string]*heartbeat{}}
+}
+
+// start extends the visibility of message every Interval until stop is
+// called for it or ctx is done. Heartbeats are off when Interval is zero.
+func (h *heartbeats) start(ctx context.Context, message Message) {
+	if h.settings.Interval <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.running[message.ReceiptHandle]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	beat := &heartbeat{cancel: cancel, done: make(chan struct{})}
+	h.running[message.ReceiptHandle] = beat
+
+	go func() {
+		defer close(beat.done)
+		defer h.remove(message, beat)
+
+		ticker := time.NewTicker(h.settings.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			// A failed extension is tried again at the next tick; the message
+			// only comes back if every try until its timeout fails
+			if err := h.queue.ExtendVisibility(ctx, message, h.settings.Timeout); err != nil && ctx.Err() == nil {
+				log.Printf("⚠️ Failed to extend visibility of message %s: %v", message.ID, err)
+			}
+		}
+	}()
+}
+
+// stop ends the heartbeats of messages and waits for them to return, so no
+// extension of these messages is made after stop returns
+func (h *heartbeats) stop(messages ...Message) {
+	var stopped []*heartbeat
+	h.mu.Lock()
+	for _, m := range messages {
+		if beat, ok := h.running[m.ReceiptHandle]; ok {
+			beat.cancel()
+			delete(h.running, m.ReceiptHandle)
+			stopped = append(stopped, beat)
+		}
+	}
+	h.mu.Unlock()
+
+	// The lock is released first: a returning heartbeat takes it in remove
+	for _, beat := range stopped {
+		<-beat.done
+	}
+}
+
+// remove drops beat once its goroutine ends, unless the message has been
+// given a new heartbeat since
+func (h *heartbeats) remove(message Message, beat *heartbeat) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	beat.cancel()
+	if h.running[message.ReceiptHandle] == beat {
+		delete(h.running, message.ReceiptHandle)
+	}
+}
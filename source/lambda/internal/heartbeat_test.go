@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// extendingQueue counts the visibility extensions made on a MemoryQueue
+type extendingQueue struct {
+	*MemoryQueue
+	extensions atomic.Int64
+}
+
+func (q *extendingQueue) ExtendVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	if timeout > 0 {
+		q.extensions.Add(1)
+	}
+	return q.MemoryQueue.ExtendVisibility(ctx, message, timeout)
+}
+
+func TestHeartbeatRunsUntilMessageIsDeleted(t *testing.T) {
+	queue := &extendingQueue{MemoryQueue: NewMemoryQueue(`not json`)}
+	cfg := Config{Visibility: VisibilitySettings{Timeout: time.Second, Interval: 5 * time.Millisecond}}
+	processor := NewProcessor(cfg, Dependencies{Queue: queue, Sink: &MemorySink{}})
+
+	ctx := context.Background()
+	messages, err := processor.ReceiveMessages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The malformed message is dead-lettered and finished, but is only
+	// deleted after the commit, so its heartbeat keeps going until then
+	if !processor.ProcessMessage(ctx, messages[0]) {
+		t.Fatal("message was not finished")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := queue.extensions.Load(); n < 2 {
+		t.Errorf("got %d extensions while the message was held, want at least 2", n)
+	}
+
+	processor.DeleteMessages(ctx, processor.CommitMessages(ctx, messages))
+	stopped := queue.extensions.Load()
+	time.Sleep(30 * time.Millisecond)
+	if n := queue.extensions.Load(); n != stopped {
+		t.Errorf("got %d extensions after the delete", n-stopped)
+	}
+}
+
+func TestHeartbeatStopsWithContext(t *testing.T) {
+	queue := &extendingQueue{MemoryQueue: NewMemoryQueue()}
+	h := newHeartbeats(queue, VisibilitySettings{Timeout: time.Second, Interval: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.start(ctx, Message{ID: "a", ReceiptHandle: "a#1"})
+	cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		h.mu.Lock()
+		running := len(h.running)
+		h.mu.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("heartbeat still running after its context was cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// slowExtendingQueue takes a while to extend visibility, whatever the context
+type slowExtendingQueue struct {
+	*MemoryQueue
+	inFlight atomic.Int64
+}
+
+func (q *slowExtendingQueue) ExtendVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	q.inFlight.Add(1)
+	defer q.inFlight.Add(-1)
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func TestHeartbeatStopWaitsForExtension(t *testing.T) {
+	queue := &slowExtendingQueue{MemoryQueue: NewMemoryQueue()}
+	h := newHeartbeats(queue, VisibilitySettings{Timeout: time.Second, Interval: time.Millisecond})
+	message := Message{ID: "a", ReceiptHandle: "a#1"}
+
+	h.start(context.Background(), message)
+	for queue.inFlight.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A delete following stop must not race the extension being made
+	h.stop(message)
+	if n := queue.inFlight.Load(); n != 0 {
+		t.Errorf("got %d extensions still in flight after stop", n)
+	}
+}
@@ -0,0 +1,339 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// InvocationSettings shape how one invocation works through the queue
+type InvocationSettings struct {
+	// BatchSize is the most messages received at once (SQS allows 1-10);
+	// up to one batch waits for a free worker
+	BatchSize int
+
+	// Concurrency is the number of workers crawling keywords, and the most
+	// keywords crawled at once
+	Concurrency int
+
+	// MinConcurrency is the floor the adaptive concurrency limit, which
+	// starts at Concurrency, backs off to; zero fixes it at Concurrency
+	MinConcurrency int
+
+	// LatencyTarget is the slowest response still counted as healthy by the
+	// concurrency limit; zero ignores latency
+	LatencyTarget time.Duration
+
+	// SafetyMargin is kept free before the Lambda deadline: crawls stop
+	// once it is reached so buffered results can be uploaded and in-flight
+	// messages deleted instead of being killed partway through
+	SafetyMargin time.Duration
+}
+
+// RunStats summarises an invocation of Run
+type RunStats struct {
+	Receives int // receives that returned messages
+	Received int // messages received
+	Finished int // messages finished by ProcessMessage
+	Released int // messages made visible again because no time was left to crawl them
+}
+
+// commitInterval is the longest finished messages wait to be committed when
+// fewer than a batch of them have finished
+const commitInterval = time.Second
+
+// CrawlContext derives the context crawls run under: it expires
+// SafetyMargin before the deadline of ctx, if ctx has one
+func (p *Processor) CrawlContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline.Add(-p.cfg.Invocation.SafetyMargin))
+}
+
+// ProcessBatch runs ProcessMessage over messages, as many at a time as the
+// concurrency limit allows, and returns the messages that finished
+func (p *Processor) ProcessBatch(ctx context.Context, messages []Message) []Message {
+	var wg sync.WaitGroup
+	done := make([]bool, len(messages))
+
+	for i, msg := range messages {
+		// Once ctx is done ProcessMessage returns at once, so no slot is needed
+		acquired := p.concurrency.acquire(ctx)
+		wg.Add(1)
+		go func(i int, msg Message) {
+			defer wg.Done()
+			if acquired {
+				defer p.concurrency.release()
+			}
+			done[i] = p.ProcessMessage(ctx, msg)
+		}(i, msg)
+	}
+	wg.Wait()
+
+	var finished []Message
+	for i, msg := range messages {
+		if done[i] {
+			finished = append(finished, msg)
+		}
+	}
+	return finished
+}
+
+// HandleSQSEvent is the entry point for SQS event source mapping invocations.
+// Lambda deletes the batch itself once the handler returns, so every buffered
+// result is uploaded first and only the messages that did not finish are
+// reported back as batchItemFailures for SQS to redeliver.
+//
+// Requeued devices and visibility extensions go to the processor's queue
+// (Config.QueueURL), so a batch from any other queue is rejected as a whole.
+func (p *Processor) HandleSQSEvent(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	messages := make([]Message, len(event.Records))
+	for i, record := range event.Records {
+		if err := checkEventSource(record.EventSourceARN, p.cfg.QueueURL); err != nil {
+			return events.SQSEventResponse{}, err
+		}
+		messages[i] = MessageFromEvent(record)
+	}
+
+	crawlCtx, cancel := p.CrawlContext(ctx)
+	defer cancel()
+
+	p.RefreshSelectorConfig(crawlCtx)
+	done := p.ProcessBatch(crawlCtx, messages)
+
+	// The commit and the flush share one persist window, as in Run
+	persistCtx, cancelPersist := persistContext(ctx)
+	durable := append(p.CommitMessages(persistCtx, done), p.FlushResults(persistCtx)...)
+	cancelPersist()
+
+	finished := make(map[string]bool, len(messages))
+	for _, msg := range durable {
+		finished[msg.ID] = true
+	}
+
+	var response events.SQSEventResponse
+	for _, msg := range messages {
+		if !finished[msg.ID] {
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.ID})
+		}
+	}
+
+	log.Printf("📊 SQS event: %d keywords, %d failed", len(messages), len(response.BatchItemFailures))
+	return response, nil
+}
+
+// Run works through the queue as a pipeline: a receiver long-polls the queue
+// into a channel of one batch, a pool of Concurrency workers crawls the
+// messages from it as they come, and a collector commits the finished ones
+// and deletes them once their results are uploaded. A slow keyword only holds
+// up its own worker. Workers only take a message while fewer keywords than
+// the adaptive concurrency limit are being crawled.
+//
+// Receiving stops when the queue is empty or the time left before the
+// deadline of ctx, less SafetyMargin, cannot fit another keyword, a keyword
+// being expected to take as long as the slowest one so far. Received messages
+// that no longer fit are made visible again instead of being crawled.
+// Buffered results are uploaded and their messages deleted before Run
+// returns, also when receiving fails, in a single persist window.
+func (p *Processor) Run(ctx context.Context) (RunStats, error) {
+	crawlCtx, cancel := p.CrawlContext(ctx)
+	defer cancel()
+
+	p.RefreshSelectorConfig(crawlCtx)
+
+	settings := p.cfg.Invocation
+	concurrency := max(settings.Concurrency, 1)
+	log.Printf("🚀 Starting lambda execution: %d workers (concurrency limit %d), %d keywords per receive",
+		concurrency, p.concurrency.current(), settings.BatchSize)
+
+	var (
+		stats    RunStats
+		slowest  slowestDuration
+		released atomic.Int64
+	)
+	work := make(chan Message, max(settings.BatchSize, 1))
+	finished := make(chan Message, concurrency)
+
+	receiveErr := make(chan error, 1)
+	go func() {
+		defer close(work)
+		receiveErr <- p.receive(crawlCtx, work, &slowest, &stats)
+	}()
+
+	var workers sync.WaitGroup
+	for range concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				// Without a slot crawlCtx is done, and the messages left are released
+				acquired := p.concurrency.acquire(crawlCtx)
+				m, ok := <-work
+				if !ok {
+					if acquired {
+						p.concurrency.release()
+					}
+					return
+				}
+				if !acquired || !fitsBeforeDeadline(crawlCtx, slowest.get()) {
+					log.Printf("⏰ No time left to crawl message %s, releasing it", m.ID)
+					persistCtx, cancel := persistContext(ctx)
+					p.releaseMessages(persistCtx, []Message{m})
+					cancel()
+					released.Add(1)
+				} else {
+					start := time.Now()
+					if p.ProcessMessage(crawlCtx, m) {
+						finished <- m
+					}
+					slowest.observe(time.Since(start))
+				}
+				if acquired {
+					p.concurrency.release()
+				}
+			}
+		}()
+	}
+
+	var pending []Message
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		stats.Finished, pending = p.collect(ctx, finished, max(settings.BatchSize, 1))
+	}()
+
+	workers.Wait()
+	close(finished)
+	<-collected
+	stats.Released = int(released.Load())
+	err := <-receiveErr
+
+	// The last finished messages and the buffered results share one persist
+	// window, so the margin left when the crawls stopped covers both
+	persistCtx, cancelPersist := persistContext(ctx)
+	p.commitFinished(persistCtx, pending, stats.Finished)
+	p.flush(persistCtx)
+	cancelPersist()
+
+	log.Printf("📊 %d keywords received in %d batches: %d finished, %d released (concurrency limit %d)",
+		stats.Received, stats.Receives, stats.Finished, stats.Released, p.concurrency.current())
+	return stats, err
+}
+
+// receive feeds work until the queue is empty, no further keyword fits
+// before the deadline of ctx, or receiving fails. Sending blocks while every
+// worker is busy and the channel is full, so no more than a batch waits.
+// Received messages are kept hidden from the start, as they may wait.
+func (p *Processor) receive(ctx context.Context, work chan<- Message, slowest *slowestDuration, stats *RunStats) error {
+	for {
+		if !fitsBeforeDeadline(ctx, slowest.get()) {
+			log.Printf("⏰ Lambda deadline is close, stopping (slowest keyword took %s)", slowest.get().Round(time.Millisecond))
+			return nil
+		}
+
+		messages, err := p.ReceiveMessages(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("⏰ Lambda deadline is close, stopping")
+				return nil
+			}
+			log.Printf("❌ Failed to receive messages: %v", err)
+			return err
+		}
+		if len(messages) == 0 {
+			log.Printf("🏁 No messages to process, stopping")
+			return nil
+		}
+
+		stats.Receives++
+		stats.Received += len(messages)
+		for _, m := range messages {
+			p.heartbeats.start(ctx, m)
+			work <- m
+		}
+	}
+}
+
+// collect commits the messages workers finish, a batch at a time or every
+// commitInterval, and deletes those whose results are uploaded. Once finished
+// is closed it returns the number of finished messages and the ones not
+// committed yet.
+func (p *Processor) collect(ctx context.Context, finished <-chan Message, batchSize int) (int, []Message) {
+	ticker := time.NewTicker(commitInterval)
+	defer ticker.Stop()
+
+	count := 0
+	var pending []Message
+	for {
+		select {
+		case m, ok := <-finished:
+			if !ok {
+				return count, pending
+			}
+			count++
+			pending = append(pending, m)
+			if len(pending) >= batchSize {
+				p.commitFinished(ctx, pending, count)
+				pending = nil
+			}
+		case <-ticker.C:
+			p.commitFinished(ctx, pending, count)
+			pending = nil
+		}
+	}
+}
+
+// commitFinished commits finished messages and deletes those whose results
+// are uploaded; the rest wait in the result buffer for a later commit or the
+// flush. total is the number of messages finished so far, for the log.
+func (p *Processor) commitFinished(ctx context.Context, pending []Message, total int) {
+	if len(pending) == 0 {
+		return
+	}
+	durable := p.CommitMessages(ctx, pending)
+	failed := p.DeleteMessages(ctx, durable)
+	log.Printf("✅ %d keywords finished (%d deleted, %d not deleted; total %d)",
+		len(pending), len(durable)-len(failed), len(failed), total)
+}
+
+// flush uploads the results still buffered and deletes the messages that
+// were waiting for them
+func (p *Processor) flush(ctx context.Context) {
+	durable := p.FlushResults(ctx)
+	failed := p.DeleteMessages(ctx, durable)
+	log.Printf("📦 Results flushed: %d messages deleted, %d not deleted", len(durable)-len(failed), len(failed))
+}
+
+// fitsBeforeDeadline reports whether work expected to take estimate can
+// finish before the deadline of ctx
+func fitsBeforeDeadline(ctx context.Context, estimate time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > estimate
+}
+
+// slowestDuration is the longest duration observed so far, safe for concurrent use
+type slowestDuration struct {
+	nanos atomic.Int64
+}
+
+func (d *slowestDuration) observe(took time.Duration) {
+	for {
+		current := d.nanos.Load()
+		if int64(took) <= current || d.nanos.CompareAndSwap(current, int64(took)) {
+			return
+		}
+	}
+}
+
+func (d *slowestDuration) get() time.Duration {
+	return time.Duration(d.nanos.Load())
+}
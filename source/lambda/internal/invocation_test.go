@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowDeadLetters is a dead-letter queue taking delay to accept the dead
+// letter of a message whose body is in slow, so that its crawl is slow
+type slowDeadLetters struct {
+	*MemoryQueue
+	slow  map[string]bool
+	delay time.Duration
+
+	mu    sync.Mutex
+	order []string // original bodies in the order they were sent
+}
+
+func (q *slowDeadLetters) Send(ctx context.Context, body string) error {
+	var letter DeadLetter
+	if err := json.Unmarshal([]byte(body), &letter); err != nil {
+		return err
+	}
+	if q.slow[letter.OriginalBody] {
+		time.Sleep(q.delay)
+	}
+	q.mu.Lock()
+	q.order = append(q.order, letter.OriginalBody)
+	q.mu.Unlock()
+	return q.MemoryQueue.Send(ctx, body)
+}
+
+// malformedMessages returns n bodies that are dead-lettered without a crawl
+func malformedMessages(n int) []string {
+	bodies := make([]string, n)
+	for i := range bodies {
+		bodies[i] = "not json"
+	}
+	return bodies
+}
+
+func TestRunDrainsQueue(t *testing.T) {
+	queue := NewMemoryQueue(malformedMessages(25)...)
+	cfg := Config{Invocation: InvocationSettings{BatchSize: 10, Concurrency: 4, SafetyMargin: time.Second}}
+	processor := NewProcessor(cfg, Dependencies{Queue: queue, Sink: &MemorySink{}})
+
+	stats, err := processor.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Receives != 3 || stats.Received != 25 || stats.Finished != 25 || stats.Released != 0 {
+		t.Errorf("got %+v, want 25 messages in 3 receives, all finished", stats)
+	}
+	if got := len(queue.Deleted()); got != 25 {
+		t.Errorf("got %d deleted messages, want 25", got)
+	}
+}
+
+func TestRunDoesNotWaitForSlowKeyword(t *testing.T) {
+	bodies := []string{"slow", "fast-1", "fast-2", "fast-3", "fast-4", "fast-5"}
+	queue := NewMemoryQueue(bodies...)
+	queue.BatchSize = 2
+	deadLetters := &slowDeadLetters{MemoryQueue: NewMemoryQueue(), slow: map[string]bool{"slow": true}, delay: 100 * time.Millisecond}
+	cfg := Config{Invocation: InvocationSettings{BatchSize: 2, Concurrency: 2, SafetyMargin: time.Second}}
+	processor := NewProcessor(cfg, Dependencies{Queue: queue, DeadLetters: deadLetters, Sink: &MemorySink{}})
+
+	if _, err := processor.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other worker keeps receiving past the slow keyword's batch
+	order := deadLetters.order
+	if len(order) != len(bodies) || order[len(order)-1] != "slow" {
+		t.Errorf("got keywords finished in order %v, want the slow one last", order)
+	}
+	if got := len(queue.Deleted()); got != len(bodies) {
+		t.Errorf("got %d deleted messages, want %d", got, len(bodies))
+	}
+}
+
+func TestRunReleasesMessagesThatDoNotFit(t *testing.T) {
+	queue := NewMemoryQueue(malformedMessages(20)...)
+	queue.BatchSize = 5
+	deadLetters := &slowDeadLetters{MemoryQueue: NewMemoryQueue(), slow: map[string]bool{"not json": true}, delay: 30 * time.Millisecond}
+	cfg := Config{Invocation: InvocationSettings{BatchSize: 5, Concurrency: 1, SafetyMargin: 10 * time.Millisecond}}
+	processor := NewProcessor(cfg, Dependencies{Queue: queue, DeadLetters: deadLetters, Sink: &MemorySink{}})
+
+	// After the first keyword has taken 30ms, at most 10ms are left before the margin
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	stats, err := processor.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Finished != 1 || stats.Released != stats.Received-1 {
+		t.Errorf("got %+v, want one keyword finished and the other received ones released", stats)
+	}
+	if len(queue.Deleted()) != 1 || len(queue.Visible()) != 19 || queue.InFlight() != 0 {
+		t.Errorf("got %d deleted, %d visible and %d in flight, want 1, 19 and 0",
+			len(queue.Deleted()), len(queue.Visible()), queue.InFlight())
+	}
+}
+
+func TestPersistContextSharesItsWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A persist window outlives the cancellation of the crawl context
+	persistCtx, cancelPersist := persistContext(ctx)
+	defer cancelPersist()
+	deadline, ok := persistCtx.Deadline()
+	if !ok || persistCtx.Err() != nil {
+		t.Fatalf("got deadline %v (%v), err %v; want an open window", deadline, ok, persistCtx.Err())
+	}
+
+	// Steps run under it do not extend it
+	time.Sleep(10 * time.Millisecond)
+	nested, cancelNested := persistContext(persistCtx)
+	defer cancelNested()
+	if got, _ := nested.Deadline(); !got.Equal(deadline) {
+		t.Errorf("got nested deadline %v, want the shared %v", got, deadline)
+	}
+}
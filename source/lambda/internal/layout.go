@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// ErrLayoutChanged is returned (wrapped in a *LayoutChangedError) when a page
+// looks like a Naver result page but none of the configured selectors match it
+var ErrLayoutChanged = errors.New("result page layout changed")
+
+// LayoutMarkers are selectors for structural parts of a result page that do
+// not depend on the ad item selectors. They let an empty extraction be told
+// apart from a page whose markup changed under us.
+type LayoutMarkers struct {
+	// SERP matches containers present on every normal search result page
+	SERP []string `json:"serp"`
+
+	// Ads matches the ad block itself (container or its heading)
+	Ads []string `json:"ads"`
+
+	// Blocked matches captcha/abuse pages served instead of results
+	Blocked []string `json:"blocked,omitempty"`
+}
+
+// Validate checks that every marker selector compiles
+func (m LayoutMarkers) Validate() error {
+	var all []string
+	all = append(all, m.SERP...)
+	all = append(all, m.Ads...)
+	all = append(all, m.Blocked...)
+	for _, sel := range all {
+		if _, err := cascadia.Compile(sel); err != nil {
+			return fmt.Errorf("layout marker %q is invalid: %w", sel, err)
+		}
+	}
+	return nil
+}
+
+// LayoutChangedError describes a page that parsed to zero ads although it
+// should have had some. Fingerprint identifies the page structure so alerts
+// for the same new layout can be grouped.
+type LayoutChangedError struct {
+	Device      string
+	Keyword     string
+	Reason      string
+	Fingerprint string
+}
+
+func (e *LayoutChangedError) Error() string {
+	return fmt.Sprintf("%s: %s (device=%s, keyword=%s, fingerprint=%s)",
+		ErrLayoutChanged, e.Reason, e.Device, e.Keyword, e.Fingerprint)
+}
+
+func (e *LayoutChangedError) Is(target error) bool {
+	return target == ErrLayoutChanged
+}
+
+// detectBlocked returns an ErrBlocked error when doc is a captcha/abuse page
+func detectBlocked(doc *goquery.Document, markers LayoutMarkers) error {
+	if matchesAny(doc, markers.Blocked) {
+		return &ScrapeError{StatusCode: 200, Err: fmt.Errorf("%w: captcha page served", ErrBlocked)}
+	}
+	return nil
+}
+
+// detectLayoutChange is called when extraction found no ads. It returns nil
+// when the page is a normal result page without an ad block (no ads for the
+// keyword) and a *LayoutChangedError otherwise. Devices without markers are
+// never reported.
+func detectLayoutChange(doc *goquery.Document, keyword, device string, markers LayoutMarkers) error {
+	if len(markers.SERP) == 0 {
+		return nil
+	}
+
+	if !matchesAny(doc, markers.SERP) {
+		return &LayoutChangedError{
+			Device:      device,
+			Keyword:     keyword,
+			Reason:      "search page markers not found",
+			Fingerprint: pageFingerprint(doc),
+		}
+	}
+
+	if matchesAny(doc, markers.Ads) {
+		return &LayoutChangedError{
+			Device:      device,
+			Keyword:     keyword,
+			Reason:      "ad block present but no result selector matched",
+			Fingerprint: pageFingerprint(doc),
+		}
+	}
+
+	return nil
+}
+
+// matchesAny reports whether any of the selectors matches an element in doc
+func matchesAny(doc *goquery.Document, selectors []string) bool {
+	for _, sel := range selectors {
+		if doc.Find(sel).Length() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fingerprintDepth is how many levels below <body> contribute to a fingerprint
+const fingerprintDepth = 4
+
+// pageFingerprint hashes the tag/id/class skeleton of the top of the page.
+// Text and attribute values are ignored so the same layout fingerprints the
+// same across keywords.
+func pageFingerprint(doc *goquery.Document) string {
+	var parts []string
+	var walk func(s *goquery.Selection, depth int)
+	walk = func(s *goquery.Selection, depth int) {
+		if depth > fingerprintDepth {
+			return
+		}
+		s.Children().Each(func(_ int, child *goquery.Selection) {
+			parts = append(parts, fmt.Sprintf("%d:%s", depth, elementSignature(child)))
+			walk(child, depth+1)
+		})
+	}
+	walk(doc.Find("body"), 0)
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// elementSignature renders an element as tag#id.class1.class2 with sorted classes
+func elementSignature(s *goquery.Selection) string {
+	signature := goquery.NodeName(s)
+	if id, ok := s.Attr("id"); ok && id != "" {
+		signature += "#" + id
+	}
+	if class, ok := s.Attr("class"); ok {
+		classes := strings.Fields(class)
+		sort.Strings(classes)
+		for _, c := range classes {
+			signature += "." + c
+		}
+	}
+	return signature
+}
@@ -1,65 +1,358 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"sync"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sqs"
-	//	"time"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
-var (
-	sqsClient = sqs.New(session.Must(session.NewSession(&aws.Config{Region: aws.String("ap-northeast-2")})))
-	queueURL  = "https://sqs.ap-northeast-2.amazonaws.com/289023186990/skale-hourly-keyword-queue"
-)
+// Processor crawls queued keywords and stores their results
+type Processor struct {
+	cfg         Config
+	deps        Dependencies
+	results     *ResultBuffer
+	heartbeats  *heartbeats
+	concurrency *concurrencyLimiter
+}
+
+// Dependencies are the external systems a Processor talks to. Production
+// code gets them from NewAWSDependencies; tests can use MemoryQueue and
+// MemorySink to run end-to-end without AWS.
+type Dependencies struct {
+	// Queue is the keyword queue
+	Queue Queue
+
+	// DeadLetters receives dead letters; when nil they are only logged
+	DeadLetters Queue
+
+	// Sink stores crawl results
+	Sink ResultSink
 
-func ReceiveMessages() ([]*sqs.Message, error) {
-	resp, err := sqsClient.ReceiveMessage(&sqs.ReceiveMessageInput{
-		QueueUrl:            aws.String(queueURL),
-		MaxNumberOfMessages: aws.Int64(10),
-		WaitTimeSeconds:     aws.Int64(2),
-		VisibilityTimeout:   aws.Int64(5),
-	})
+	// Archive stores the raw result pages; when nil they are not kept
+	Archive PageArchive
+
+	// S3 reads the selector config when Config.Selectors.S3URI is set
+	S3 s3iface.S3API
+}
+
+// NewAWSDependencies builds SQS and S3 backed dependencies from cfg
+func NewAWSDependencies(cfg Config) (Dependencies, error) {
+	sess, err := NewAWSSession(cfg)
 	if err != nil {
-		return nil, err
+		return Dependencies{}, fmt.Errorf("failed to create AWS session: %w", err)
 	}
-	return resp.Messages, nil
-}
 
-func deleteMessage(receiptHandle *string) {
-	_, err := sqsClient.DeleteMessage(&sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(queueURL),
-		ReceiptHandle: receiptHandle,
-	})
+	sqsClient := NewSQSClient(sess, cfg)
+	s3Client := NewS3Client(sess, cfg)
+
+	encoder, err := NewEncoder(cfg.ResultFormat)
 	if err != nil {
-		log.Printf("😡 [ERROR] Failed to delete message: %v", err)
+		return Dependencies{}, err
+	}
+	sink := NewS3ResultSink(s3Client, cfg.Bucket)
+	sink.Encoder = encoder
+
+	queue := NewSQSQueue(sqsClient, cfg.QueueURL)
+	queue.VisibilityTimeout = cfg.Visibility.Timeout
+	queue.MaxMessages = int64(cfg.Invocation.BatchSize)
+
+	deps := Dependencies{
+		Queue: queue,
+		Sink:  sink,
+		S3:    s3Client,
+	}
+	if cfg.DeadLetterQueueURL != "" {
+		deps.DeadLetters = NewSQSQueue(sqsClient, cfg.DeadLetterQueueURL)
+	}
+	if cfg.Archive.Enabled {
+		deps.Archive = NewS3PageArchive(s3Client, cfg.Bucket)
+	}
+	return deps, nil
+}
+
+// NewProcessor returns a Processor using cfg and deps
+func NewProcessor(cfg Config, deps Dependencies) *Processor {
+	return &Processor{
+		cfg:         cfg,
+		deps:        deps,
+		results:     NewResultBuffer(deps.Sink, cfg.Batch),
+		heartbeats:  newHeartbeats(deps.Queue, cfg.Visibility),
+		concurrency: newConcurrencyLimiter(cfg.Invocation),
+	}
+}
+
+// persistTimeout bounds the delete/requeue/upload steps that run after a
+// crawl has finished. They are detached from the crawl context so a message
+// whose results were already scraped is not abandoned halfway.
+const persistTimeout = 2 * time.Second
+
+// minSafetyMargin is the time that has to be left before the Lambda deadline
+// when the crawls stop: one persist window for the messages being crawled to
+// settle, and one shared by the final commit and the flush
+const minSafetyMargin = 2 * persistTimeout
+
+// ReceiveMessages receives the next batch of keyword messages
+func (p *Processor) ReceiveMessages(ctx context.Context) ([]Message, error) {
+	return p.deps.Queue.Receive(ctx)
+}
+
+// DeleteMessages removes processed messages from the queue and returns the
+// ones that could not be deleted (they will be redelivered)
+func (p *Processor) DeleteMessages(ctx context.Context, messages []Message) []Message {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	persistCtx, cancel := persistContext(ctx)
+	defer cancel()
+
+	p.heartbeats.stop(messages...)
+	failed := p.deps.Queue.Delete(persistCtx, messages)
+	for _, m := range failed {
+		log.Printf("😡 [ERROR] Failed to delete message %s, it will be redelivered", m.ID)
+	}
+	return failed
+}
+
+// CommitMessages hands messages ProcessMessage finished to the result buffer,
+// uploads the batches that are full, and returns the messages whose results
+// are now durable and may be deleted. Messages whose batches are still
+// buffered are returned by a later commit or by FlushResults.
+func (p *Processor) CommitMessages(ctx context.Context, finished []Message) []Message {
+	persistCtx, cancel := persistContext(ctx)
+	defer cancel()
+	durable, lost := p.results.Commit(persistCtx, finished)
+	p.releaseLost(persistCtx, lost)
+	return durable
+}
+
+// FlushResults uploads every buffered result and returns the remaining
+// messages that may be deleted. Handlers call it before returning.
+func (p *Processor) FlushResults(ctx context.Context) []Message {
+	persistCtx, cancel := persistContext(ctx)
+	defer cancel()
+	durable, lost := p.results.Flush(persistCtx)
+	p.releaseLost(persistCtx, lost)
+	return durable
+}
+
+// releaseLost releases the messages whose results could not be uploaded so
+// they are crawled anew instead of waiting out their visibility timeout
+func (p *Processor) releaseLost(ctx context.Context, messages []Message) {
+	for _, m := range messages {
+		log.Printf("😡 [ERROR] Results of message %s were not uploaded, releasing it for redelivery", m.ID)
+	}
+	p.releaseMessages(ctx, messages)
+}
+
+// releaseMessages makes messages visible again right away. A message that
+// cannot be released is redelivered after its visibility timeout.
+func (p *Processor) releaseMessages(ctx context.Context, messages []Message) {
+	p.heartbeats.stop(messages...)
+	for _, m := range messages {
+		if err := p.deps.Queue.ExtendVisibility(ctx, m, 0); err != nil {
+			log.Printf("😡 [ERROR] Failed to release message %s, it will be redelivered after its visibility timeout: %v", m.ID, err)
+		}
 	}
 }
 
-func ProcessMessage(message *sqs.Message, wg *sync.WaitGroup, sem chan struct{}) {
-	defer wg.Done()
-	defer func() { <-sem }()
+// requeueDevices sends a new message per failed device so that only those
+// devices are crawled again. The new messages carry the attempts made so far,
+// which their own receive count adds to. Returns false if any message could
+// not be sent.
+func (p *Processor) requeueDevices(ctx context.Context, request SearchRequest, devices []string, attempts int) bool {
+	for _, device := range devices {
+		body, err := json.Marshal(SearchRequest{Keyword: request.Keyword, Device: device, Retries: attempts})
+		if err != nil {
+			log.Printf("😡 [ERROR] Failed to encode retry message for %s (%s): %v", request.Keyword, device, err)
+			return false
+		}
+
+		if err := p.deps.Queue.Send(ctx, string(body)); err != nil {
+			log.Printf("😡 [ERROR] Failed to requeue %s for %s: %v", device, request.Keyword, err)
+			return false
+		}
+	}
+	return true
+}
+
+// targetDevices resolves which devices a request should be crawled on.
+// An empty device or "ALL" means both desktop and mobile; any other value
+// must match a registered DeviceProfile.
+func targetDevices(device string) ([]string, error) {
+	device = strings.ToUpper(strings.TrimSpace(device))
+	switch device {
+	case "", DeviceAll:
+		return []string{DeviceDesktop, DeviceMobile}, nil
+	}
+	if _, ok := ScraperFor(device); !ok {
+		return nil, fmt.Errorf("unknown device: %q", device)
+	}
+	return []string{device}, nil
+}
+
+// scrapeDevice runs the scraper registered for the given device
+func scrapeDevice(ctx context.Context, device, keyword string) (PageResult, error) {
+	scraper, ok := ScraperFor(device)
+	if !ok {
+		return PageResult{}, fmt.Errorf("unknown device: %q", device)
+	}
+	return scraper.Scrape(ctx, keyword)
+}
+
+// ProcessMessage crawls the keyword in message on the requested devices,
+// buffers the results and reports whether the message is finished. A
+// finished message may be deleted once CommitMessages or FlushResults has
+// written its results. It returns
+// false when the message should be redelivered: ctx was done and the crawl
+// abandoned, every device failed transiently, or a follow-up step (requeue,
+// dead letter) could not be completed. Malformed messages, permanent
+// failures and keywords past Config.MaxReceiveCount are dead-lettered.
+//
+// The message's visibility is extended until it is deleted or released, or
+// ctx is done; an unfinished message is left to time out.
+func (p *Processor) ProcessMessage(ctx context.Context, message Message) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	p.heartbeats.start(ctx, message)
+	finished := p.processMessage(ctx, message)
+	if !finished {
+		p.heartbeats.stop(message)
+	}
+	return finished
+}
+
+// processMessage is ProcessMessage without the visibility heartbeat
+func (p *Processor) processMessage(ctx context.Context, message Message) bool {
+
+	attempts := message.ReceiveCount
 
 	var body SearchRequest
-	err := json.Unmarshal([]byte(*message.Body), &body)
+	err := json.Unmarshal([]byte(message.Body), &body)
 	if err != nil {
 		log.Printf("😡 [ERROR] Failed to parse message: %v", err)
-		return
+		persistCtx, cancel := persistContext(ctx)
+		defer cancel()
+		return p.sendDeadLetter(persistCtx, message, attempts, ReasonParseError, "", err)
 	}
+	attempts += body.Retries
 
-	desktopResults, err := ScrapeDesktopResults(body.Keyword)
+	devices, err := targetDevices(body.Device)
+	if err == nil && strings.TrimSpace(body.Keyword) == "" {
+		err = errors.New("keyword is empty")
+	}
 	if err != nil {
-		log.Printf("😡 [ERROR] Crawling error in PC for %s: %v", body.Keyword, err)
-		return
+		log.Printf("😡 [ERROR] Invalid request %q: %v", body.Keyword, err)
+		persistCtx, cancel := persistContext(ctx)
+		defer cancel()
+		return p.sendDeadLetter(persistCtx, message, attempts, ReasonInvalidRequest, "", err)
 	}
 
-	deleteMessage(message.ReceiptHandle)
+	// All devices of one keyword share a single attempt budget for retries
+	ctx = WithRetryPolicy(ctx, p.cfg.Retry)
+	ctx = WithAttemptBudget(ctx, NewAttemptBudget(p.cfg.Retry.KeywordBudget))
+	ctx = WithAdPageDepth(ctx, p.cfg.AdPageDepth)
+	ctx = withConcurrencyLimiter(ctx, p.concurrency)
+	if p.deps.Archive != nil {
+		ctx = WithPageArchive(ctx, p.deps.Archive)
+	}
 
-	if len(desktopResults) > 0 {
-		uploadResult(desktopResults, body.Keyword)
-		log.Printf("✅ Crawling completed: %s", body.Keyword)
+	// Each device is crawled on its own so one failure does not drop the other's results
+	pagesByDevice := make(map[string]PageResult, len(devices))
+	failures := make(map[string]error)
+	for _, device := range devices {
+		page, err := scrapeDevice(ctx, device, body.Keyword)
+		if isContextDone(err) {
+			log.Printf("⏰ Crawl of %s cancelled, leaving message on the queue: %v", body.Keyword, err)
+			return false
+		}
+		if err != nil {
+			log.Printf("😡 [ERROR] Crawling error in %s for %s: %v", device, body.Keyword, err)
+			recordScrapeError(device, err)
+			failures[device] = err
+			continue
+		}
+		pagesByDevice[device] = page
 	}
+
+	persistCtx, cancel := persistContext(ctx)
+	defer cancel()
+
+	// Permanent failures, and transient ones past the attempt cap, are
+	// dead-lettered; the remaining transient failures are retried.
+	settled := true
+	var retry []string
+	for _, device := range devices {
+		err, failed := failures[device]
+		if !failed {
+			continue
+		}
+		reason, permanent := classifyFailure(err)
+		if !permanent && attempts < p.cfg.MaxReceiveCount {
+			retry = append(retry, device)
+			continue
+		}
+		if !permanent {
+			reason = ReasonMaxReceives
+		}
+		if !p.sendDeadLetter(persistCtx, message, attempts, reason, device, err) {
+			settled = false
+		}
+	}
+
+	// Nothing to keep from this attempt: let SQS redeliver the whole message
+	if len(retry) == len(devices) {
+		return false
+	}
+
+	// Only the failed devices are retried; if that cannot be arranged the
+	// whole message is left on the queue to be redelivered, and its results
+	// are not buffered since the redelivery crawls them again
+	if !settled || (len(retry) > 0 && !p.requeueDevices(persistCtx, body, retry, attempts)) {
+		return false
+	}
+
+	for _, device := range devices {
+		if page, ok := pagesByDevice[device]; ok {
+			p.results.Add(message, device, page)
+		}
+	}
+	log.Printf("✅ Crawling completed: %s", body.Keyword)
+	return true
+}
+
+type persistWindowKey struct{}
+
+// persistContext returns the context for the delete/requeue/upload steps that
+// follow a crawl. It outlives ctx's cancellation, bounded by persistTimeout.
+// Steps given a context persistContext returned share its window instead of
+// opening one of their own.
+func persistContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Value(persistWindowKey{}) != nil {
+		return context.WithCancel(ctx)
+	}
+	persistCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), persistTimeout)
+	return context.WithValue(persistCtx, persistWindowKey{}, true), cancel
+}
+
+// recordScrapeError publishes alert metrics for scrape failures that need attention
+func recordScrapeError(device string, err error) {
+	var layoutErr *LayoutChangedError
+	if errors.As(err, &layoutErr) {
+		// The fingerprint is in the error log line; as a dimension it would explode cardinality
+		emitMetric("LayoutChanged", 1, UnitCount, map[string]string{"Device": device})
+	}
+}
+
+// isContextDone reports whether err was caused by a cancelled or expired context
+func isContextDone(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
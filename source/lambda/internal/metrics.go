@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// metricsNamespace is the CloudWatch namespace metrics are published under
+var metricsNamespace atomic.Pointer[string]
+
+// ApplyMetricsNamespace sets the namespace metrics are published under from cfg
+func ApplyMetricsNamespace(cfg Config) {
+	namespace := cfg.MetricsNamespace
+	metricsNamespace.Store(&namespace)
+}
+
+// currentMetricsNamespace returns the namespace set by ApplyMetricsNamespace,
+// or the default one
+func currentMetricsNamespace() string {
+	if namespace := metricsNamespace.Load(); namespace != nil && *namespace != "" {
+		return *namespace
+	}
+	return defaultMetricsNamespace
+}
+
+// Metric units understood by CloudWatch
+const (
+	UnitCount          = "Count"
+	UnitMilliseconds   = "Milliseconds"
+	UnitCountPerSecond = "Count/Second"
+)
+
+// emitMetric publishes a single metric using the CloudWatch embedded metric
+// format: a JSON log line that CloudWatch Logs turns into a metric, so no
+// PutMetricData call is needed from the Lambda.
+func emitMetric(name string, value float64, unit string, dimensions map[string]string) {
+	dimensionKeys := make([]string, 0, len(dimensions))
+	record := map[string]interface{}{}
+	for key, dimValue := range dimensions {
+		dimensionKeys = append(dimensionKeys, key)
+		record[key] = dimValue
+	}
+	record[name] = value
+	record["_aws"] = map[string]interface{}{
+		"Timestamp": time.Now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  currentMetricsNamespace(),
+			"Dimensions": [][]string{dimensionKeys},
+			"Metrics":    []map[string]string{{"Name": name, "Unit": unit}},
+		}},
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("⚠️ Failed to encode metric %s: %v", name, err)
+		return
+	}
+	log.Print(string(line))
+}
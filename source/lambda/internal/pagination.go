@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// MaxAdPageDepth caps how many pages a keyword may be crawled to
+const MaxAdPageDepth = 10
+
+// PaginationSelectors lead from a result page to Naver's ad list pages
+// ("더보기"), which list the ads beyond the ones shown on the result page.
+// Pagination is off when More is empty.
+type PaginationSelectors struct {
+	// More matches the link from the result page to the first ad list page
+	More string `json:"more,omitempty"`
+
+	// Next matches the link from an ad list page to the following one
+	Next string `json:"next,omitempty"`
+
+	// AdList extracts the ads of an ad list page; without it the result
+	// page's selectors are used
+	AdList *SelectorSet `json:"ad_list,omitempty"`
+}
+
+// adListSelectors returns the selectors for the ad list pages of sel
+func (sel SelectorSet) adListSelectors() SelectorSet {
+	if sel.Pagination.AdList != nil {
+		return *sel.Pagination.AdList
+	}
+	return sel
+}
+
+// validate checks the pagination selectors and the ad list selector set
+func (p PaginationSelectors) validate() error {
+	if err := compileOptional([]struct{ name, value string }{
+		{"pagination more", p.More},
+		{"pagination next", p.Next},
+	}); err != nil {
+		return err
+	}
+	if p.AdList == nil {
+		return nil
+	}
+	if p.AdList.Pagination.More != "" || p.AdList.Pagination.AdList != nil {
+		return fmt.Errorf("ad list selectors cannot paginate themselves")
+	}
+	if err := p.AdList.Validate(); err != nil {
+		return fmt.Errorf("ad list: %w", err)
+	}
+	return nil
+}
+
+type adPageDepthKey struct{}
+
+// WithAdPageDepth sets how many pages scrapers made with ctx crawl per
+// keyword: 1 is the result page only, 2 adds the first ad list page, ...
+func WithAdPageDepth(ctx context.Context, depth int) context.Context {
+	return context.WithValue(ctx, adPageDepthKey{}, depth)
+}
+
+// adPageDepthFrom returns the page depth attached to ctx, or 1
+func adPageDepthFrom(ctx context.Context) int {
+	if depth, ok := ctx.Value(adPageDepthKey{}).(int); ok && depth > 1 {
+		return min(depth, MaxAdPageDepth)
+	}
+	return 1
+}
+
+// nextPageURL resolves the href of the first match of selector against the
+// URL of the page it was found on. It returns "" when there is no such link.
+func nextPageURL(doc *goquery.Document, pageURL, selector string) string {
+	if selector == "" {
+		return ""
+	}
+	href, ok := doc.Find(selector).First().Attr("href")
+	href = strings.TrimSpace(href)
+	if !ok || href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "javascript:") {
+		return ""
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	next, err := base.Parse(href)
+	if err != nil || (next.Scheme != "http" && next.Scheme != "https") {
+		return ""
+	}
+	return next.String()
+}
+
+// adPager merges the ads of consecutive pages of one crawl. The first ad list
+// page repeats the ads of the result page, so ads already seen on an earlier
+// page are dropped and the rank keeps counting over the rest in page order,
+// in each ad's rank sequence.
+type adPager struct {
+	ads   []SearchResult
+	seen  map[string]bool
+	ranks adRanks
+}
+
+// add appends the ads of a page that did not appear on an earlier page,
+// numbering them after the ads kept so far, and returns how many were added
+func (p *adPager) add(ads []SearchResult, page int) int {
+	if p.seen == nil {
+		p.seen = map[string]bool{}
+		p.ranks = adRanks{}
+	}
+	added := 0
+	var keys []string
+	for _, ad := range ads {
+		key := adKey(ad)
+		if key != "" && p.seen[key] {
+			continue
+		}
+		keys = append(keys, key)
+		ad.Page = page
+		ad.Rank = p.ranks.next(ad.AdBlock)
+		p.ads = append(p.ads, ad)
+		added++
+	}
+	for _, key := range keys {
+		if key != "" {
+			p.seen[key] = true
+		}
+	}
+	return added
+}
+
+// adKey identifies an ad across pages; ads without a title or URL have none
+func adKey(ad SearchResult) string {
+	if ad.Title == "" || ad.DisplayURL == "" {
+		return ""
+	}
+	return ad.Title + "\x00" + ad.DisplayURL
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScrapeFollowsAdListPages(t *testing.T) {
+	fake := NewFakeNaver(filepath.Join("testdata", "serp"))
+	defer fake.Close()
+
+	tests := []struct {
+		profile  DeviceProfile
+		depth    int
+		pages    []int // page of each ad, in rank order
+		requests int
+	}{
+		{DesktopProfile, 1, []int{1, 1}, 1},
+		{DesktopProfile, 2, []int{1, 1, 2}, 2},
+		{DesktopProfile, 3, []int{1, 1, 2, 3, 3}, 3},
+		// The second ad list page has no next link
+		{DesktopProfile, MaxAdPageDepth, []int{1, 1, 2, 3, 3}, 3},
+		// The mobile ad list has a single page
+		{MobileProfile, 3, []int{1, 2}, 2},
+	}
+	for _, tt := range tests {
+		before := fake.Requests("paginated")
+		archive := &MemoryArchive{}
+		ctx := WithRetryPolicy(context.Background(), fastRetry)
+		ctx = WithAdPageDepth(ctx, tt.depth)
+		// Only retries use the budget, so it does not limit the pages followed
+		ctx = WithAttemptBudget(ctx, NewAttemptBudget(1))
+		ctx = WithPageArchive(ctx, archive)
+
+		page, err := fakeScraper(t, fake, tt.profile).Scrape(ctx, "paginated")
+		if err != nil {
+			t.Fatalf("%s depth %d: %v", tt.profile.Device, tt.depth, err)
+		}
+
+		var pages []int
+		for i, ad := range page.Ads {
+			if ad.Rank != i+1 {
+				t.Errorf("%s depth %d: ad %d has rank %d", tt.profile.Device, tt.depth, i, ad.Rank)
+			}
+			// Every page of a crawl shares its request ID
+			if ad.RequestID == "" || ad.RequestID != page.Ads[0].RequestID || ad.UserAgent == "" || ad.HTTPStatus != 200 {
+				t.Errorf("%s depth %d: ad %d has crawl meta %+v", tt.profile.Device, tt.depth, i, ad.CrawlMeta)
+			}
+			pages = append(pages, ad.Page)
+		}
+		if !reflect.DeepEqual(pages, tt.pages) {
+			t.Errorf("%s depth %d: got pages %v, want %v", tt.profile.Device, tt.depth, pages, tt.pages)
+		}
+		if got := fake.Requests("paginated") - before; got != tt.requests {
+			t.Errorf("%s depth %d: got %d requests, want %d", tt.profile.Device, tt.depth, got, tt.requests)
+		}
+		if got := len(archive.Pages()); got != tt.requests {
+			t.Errorf("%s depth %d: got %d archived pages, want %d", tt.profile.Device, tt.depth, got, tt.requests)
+		}
+
+		// The crawl is partitioned by when its result page was received
+		if page.CrawledAt.IsZero() || !page.CrawledAt.Equal(page.Ads[0].CrawledAt) {
+			t.Errorf("%s depth %d: got crawl time %v, want the result page's %v", tt.profile.Device, tt.depth, page.CrawledAt, page.Ads[0].CrawledAt)
+		}
+		for _, archived := range archive.Pages()[1:] {
+			if !archived.Partition.Equal(page.CrawledAt) {
+				t.Errorf("%s depth %d: page %d archived under %v, want %v", tt.profile.Device, tt.depth, archived.Page, archived.Partition, page.CrawledAt)
+			}
+		}
+	}
+}
+
+func TestAdPagerDropsAdsOfEarlierPages(t *testing.T) {
+	ad := func(title, url string) SearchResult { return SearchResult{Title: title, DisplayURL: url} }
+
+	var pager adPager
+	pager.add([]SearchResult{ad("A", "a.com"), ad("A", "a.com"), ad("", "")}, 1)
+	added := pager.add([]SearchResult{ad("A", "a.com"), ad("", ""), ad("B", "b.com")}, 2)
+
+	// Repeats within a page are kept; ads without a title or URL are never dropped
+	if added != 2 || len(pager.ads) != 5 {
+		t.Fatalf("got %d added, %d total; want 2 and 5", added, len(pager.ads))
+	}
+	for i, want := range []struct{ rank, page int }{{1, 1}, {2, 1}, {3, 1}, {4, 2}, {5, 2}} {
+		if got := pager.ads[i]; got.Rank != want.rank || got.Page != want.page {
+			t.Errorf("ad %d: got rank %d page %d, want %d and %d", i, got.Rank, got.Page, want.rank, want.page)
+		}
+	}
+}
+
+func TestAdPagerRanksBrandSearchApart(t *testing.T) {
+	ad := func(title, block string) SearchResult {
+		return SearchResult{Title: title, DisplayURL: title + ".com", AdBlock: block}
+	}
+
+	// The brand search ad comes first on the page but does not shift the power link ranks
+	var pager adPager
+	pager.add([]SearchResult{ad("brand", AdBlockBrandSearch), ad("A", AdBlockPowerLinkTop), ad("B", AdBlockPowerLinkBottom)}, 1)
+	pager.add([]SearchResult{ad("A", AdBlockPowerLinkTop), ad("C", AdBlockPowerLinkTop)}, 2)
+
+	for i, want := range []int{1, 1, 2, 3} {
+		if got := pager.ads[i]; got.Rank != want {
+			t.Errorf("ad %s (%s): got rank %d, want %d", got.Title, got.AdBlock, got.Rank, want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ParquetEncoder writes each table as a snappy-compressed Parquet file. The
+// row types below are the schema; glue_schema.json declares the same columns
+// for the Glue tables and must be kept in step with them.
+type ParquetEncoder struct{}
+
+func (ParquetEncoder) Extension() string   { return ".parquet" }
+func (ParquetEncoder) ContentType() string { return "application/vnd.apache.parquet" }
+
+// TablePrefix keeps the Parquet tables apart from the CSV ones, under
+// parquet/data/, parquet/organic/ and parquet/sections/
+func (ParquetEncoder) TablePrefix() string { return "parquet/" }
+
+// parquetParallelism is the number of goroutines the writer marshals rows with;
+// a page has a few dozen rows at most
+const parquetParallelism = 1
+
+// adRow is a row of the ads table (data/)
+type adRow struct {
+	Query       string         `parquet:"name=query, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Device      string         `parquet:"name=device, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Rank        int32          `parquet:"name=rank, type=INT32"`
+	SiteName    string         `parquet:"name=site_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DisplayURL  string         `parquet:"name=display_url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Title       string         `parquet:"name=title, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Description string         `parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8"`
+	LandingURL  string         `parquet:"name=landing_url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AdBlock     string         `parquet:"name=ad_block, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Sitelinks   []sitelinkItem `parquet:"name=sitelinks, type=LIST"`
+	Phone       string         `parquet:"name=phone, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Price       string         `parquet:"name=price, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Promotion   string         `parquet:"name=promotion, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Thumbnails  []string       `parquet:"name=thumbnails, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Page        int32          `parquet:"name=page, type=INT32"`
+
+	// CrawlMeta columns; the writer does not flatten embedded structs
+	CrawledAt       *int64 `parquet:"name=crawled_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	RequestID       string `parquet:"name=request_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UserAgent       string `parquet:"name=user_agent, type=BYTE_ARRAY, convertedtype=UTF8"`
+	HTTPStatus      int32  `parquet:"name=http_status, type=INT32"`
+	LatencyMs       int64  `parquet:"name=latency_ms, type=INT64"`
+	LambdaRequestID string `parquet:"name=lambda_request_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// sitelinkItem is an element of adRow.Sitelinks
+type sitelinkItem struct {
+	Title string `parquet:"name=title, type=BYTE_ARRAY, convertedtype=UTF8"`
+	URL   string `parquet:"name=url, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// organicRow is a row of the organic table (organic/)
+type organicRow struct {
+	Query       string `parquet:"name=query, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Device      string `parquet:"name=device, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Rank        int32  `parquet:"name=rank, type=INT32"`
+	Title       string `parquet:"name=title, type=BYTE_ARRAY, convertedtype=UTF8"`
+	URL         string `parquet:"name=url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DisplayURL  string `parquet:"name=display_url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Description string `parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	CrawledAt       *int64 `parquet:"name=crawled_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	RequestID       string `parquet:"name=request_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UserAgent       string `parquet:"name=user_agent, type=BYTE_ARRAY, convertedtype=UTF8"`
+	HTTPStatus      int32  `parquet:"name=http_status, type=INT32"`
+	LatencyMs       int64  `parquet:"name=latency_ms, type=INT64"`
+	LambdaRequestID string `parquet:"name=lambda_request_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// sectionRow is a row of the sections table (sections/)
+type sectionRow struct {
+	Query    string `parquet:"name=query, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Device   string `parquet:"name=device, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Position int32  `parquet:"name=position, type=INT32"`
+	Type     string `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Heading  string `parquet:"name=heading, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	CrawledAt       *int64 `parquet:"name=crawled_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	RequestID       string `parquet:"name=request_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UserAgent       string `parquet:"name=user_agent, type=BYTE_ARRAY, convertedtype=UTF8"`
+	HTTPStatus      int32  `parquet:"name=http_status, type=INT32"`
+	LatencyMs       int64  `parquet:"name=latency_ms, type=INT64"`
+	LambdaRequestID string `parquet:"name=lambda_request_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func (ParquetEncoder) EncodeAds(ads []SearchResult) ([]byte, error) {
+	rows := make([]adRow, len(ads))
+	for i, item := range ads {
+		rows[i] = adRow{
+			Query:       item.Query,
+			Device:      item.Device,
+			Rank:        int32(item.Rank),
+			SiteName:    item.SiteName,
+			DisplayURL:  item.DisplayURL,
+			Title:       item.Title,
+			Description: item.Description,
+			LandingURL:  item.LandingURL,
+			AdBlock:     item.AdBlock,
+			Phone:       item.Extensions.Phone,
+			Price:       item.Extensions.Price,
+			Promotion:   item.Extensions.Promotion,
+			Thumbnails:  item.Extensions.Thumbnails,
+			Page:        int32(item.Page),
+
+			CrawledAt:       timestampMillis(item.CrawledAt),
+			RequestID:       item.RequestID,
+			UserAgent:       item.UserAgent,
+			HTTPStatus:      int32(item.HTTPStatus),
+			LatencyMs:       item.LatencyMs,
+			LambdaRequestID: item.LambdaRequestID,
+		}
+		for _, link := range item.Extensions.Sitelinks {
+			rows[i].Sitelinks = append(rows[i].Sitelinks, sitelinkItem(link))
+		}
+	}
+	return encodeParquet(new(adRow), rows)
+}
+
+func (ParquetEncoder) EncodeOrganic(results []OrganicResult) ([]byte, error) {
+	rows := make([]organicRow, len(results))
+	for i, item := range results {
+		rows[i] = organicRow{
+			Query:       item.Query,
+			Device:      item.Device,
+			Rank:        int32(item.Rank),
+			Title:       item.Title,
+			URL:         item.URL,
+			DisplayURL:  item.DisplayURL,
+			Description: item.Description,
+
+			CrawledAt:       timestampMillis(item.CrawledAt),
+			RequestID:       item.RequestID,
+			UserAgent:       item.UserAgent,
+			HTTPStatus:      int32(item.HTTPStatus),
+			LatencyMs:       item.LatencyMs,
+			LambdaRequestID: item.LambdaRequestID,
+		}
+	}
+	return encodeParquet(new(organicRow), rows)
+}
+
+func (ParquetEncoder) EncodeSections(sections []SerpSection) ([]byte, error) {
+	rows := make([]sectionRow, len(sections))
+	for i, section := range sections {
+		rows[i] = sectionRow{
+			Query:    section.Query,
+			Device:   section.Device,
+			Position: int32(section.Position),
+			Type:     section.Type,
+			Heading:  section.Heading,
+
+			CrawledAt:       timestampMillis(section.CrawledAt),
+			RequestID:       section.RequestID,
+			UserAgent:       section.UserAgent,
+			HTTPStatus:      int32(section.HTTPStatus),
+			LatencyMs:       section.LatencyMs,
+			LambdaRequestID: section.LambdaRequestID,
+		}
+	}
+	return encodeParquet(new(sectionRow), rows)
+}
+
+// encodeParquet writes rows with the schema of the row type schema points to
+func encodeParquet[T any](schema *T, rows []T) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	pw, err := writer.NewParquetWriterFromWriter(buffer, schema, parquetParallelism)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Parquet writer: %w", err)
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for _, row := range rows {
+		if err := pw.Write(row); err != nil {
+			return nil, fmt.Errorf("failed to write Parquet row: %w", err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		return nil, fmt.Errorf("failed to finish Parquet file: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// timestampMillis converts t for a TIMESTAMP_MILLIS column; the zero time is null
+func timestampMillis(t time.Time) *int64 {
+	if t.IsZero() {
+		return nil
+	}
+	millis := t.UnixMilli()
+	return &millis
+}
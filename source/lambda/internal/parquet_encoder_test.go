@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestParquetEncoderRoundTrip(t *testing.T) {
+	meta := CrawlMeta{
+		CrawledAt:       time.Date(2025, 8, 13, 0, 5, 0, 0, time.UTC),
+		RequestID:       "req-1",
+		UserAgent:       "ua",
+		HTTPStatus:      200,
+		LatencyMs:       321,
+		LambdaRequestID: "lambda-1",
+	}
+	ads := []SearchResult{
+		{
+			Query: "shoes", Device: DeviceDesktop, CrawlMeta: meta, Rank: 1, Title: "Shoes", DisplayURL: "shoes.com", Page: 2,
+			Extensions: AdExtensions{
+				Sitelinks:  []Sitelink{{Title: "Sale", URL: "https://shoes.com/sale"}},
+				Thumbnails: []string{"https://img/1.jpg"},
+			},
+		},
+		// Records stamped without a crawl time get a null crawled_at
+		{Query: "shoes", Device: DeviceDesktop, Rank: 2},
+	}
+
+	data, err := ParquetEncoder{}.EncodeAds(ads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readParquet(t, data, new(adRow))
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+
+	got := rows[0]
+	if got.Query != "shoes" || got.Rank != 1 || got.Page != 2 || got.RequestID != "req-1" || got.HTTPStatus != 200 ||
+		got.LatencyMs != 321 || got.LambdaRequestID != "lambda-1" {
+		t.Errorf("got row %+v", got)
+	}
+	if got.CrawledAt == nil || *got.CrawledAt != meta.CrawledAt.UnixMilli() {
+		t.Errorf("got crawled_at %v, want %d", got.CrawledAt, meta.CrawledAt.UnixMilli())
+	}
+	if !reflect.DeepEqual(got.Sitelinks, []sitelinkItem{{Title: "Sale", URL: "https://shoes.com/sale"}}) ||
+		!reflect.DeepEqual(got.Thumbnails, []string{"https://img/1.jpg"}) {
+		t.Errorf("got extensions %+v and %v", got.Sitelinks, got.Thumbnails)
+	}
+	if rows[1].CrawledAt != nil {
+		t.Errorf("got crawled_at %d for a record without crawl time", *rows[1].CrawledAt)
+	}
+}
+
+// readParquet reads every row of a Parquet file into rows of schema's type
+func readParquet[T any](t *testing.T, data []byte, schema *T) []T {
+	t.Helper()
+	file, err := buffer.NewBufferFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(file, schema, parquetParallelism)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	rows := make([]T, pr.GetNumRows())
+	if err := pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// glueTable is the part of a Glue TableInput the schema test checks
+type glueTable struct {
+	Name              string
+	PartitionKeys     []glueColumn
+	StorageDescriptor struct {
+		Columns []glueColumn
+	}
+}
+
+type glueColumn struct {
+	Name string
+	Type string
+}
+
+func TestGlueSchemaMatchesParquetRows(t *testing.T) {
+	data, err := os.ReadFile("glue_schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []glueTable
+	if err := json.Unmarshal(data, &tables); err != nil {
+		t.Fatal(err)
+	}
+
+	rowTypes := map[string]reflect.Type{
+		"naver_ads":      reflect.TypeOf(adRow{}),
+		"naver_organic":  reflect.TypeOf(organicRow{}),
+		"naver_sections": reflect.TypeOf(sectionRow{}),
+	}
+	if len(tables) != len(rowTypes) {
+		t.Errorf("got %d tables, want %d", len(tables), len(rowTypes))
+	}
+	partitions := []glueColumn{{"basic_date", "string"}, {"hh", "string"}}
+	for _, table := range tables {
+		rowType, ok := rowTypes[table.Name]
+		if !ok {
+			t.Errorf("unexpected table %s", table.Name)
+			continue
+		}
+		if !reflect.DeepEqual(table.PartitionKeys, partitions) {
+			t.Errorf("%s: got partition keys %v, want %v", table.Name, table.PartitionKeys, partitions)
+		}
+		if want := parquetColumns(rowType); !reflect.DeepEqual(table.StorageDescriptor.Columns, want) {
+			t.Errorf("%s: got columns\n%v\nwant\n%v", table.Name, table.StorageDescriptor.Columns, want)
+		}
+	}
+}
+
+// parquetColumns returns the Glue columns of a Parquet row type
+func parquetColumns(rowType reflect.Type) []glueColumn {
+	var columns []glueColumn
+	for i := 0; i < rowType.NumField(); i++ {
+		field := rowType.Field(i)
+		columns = append(columns, glueColumn{Name: parquetName(field.Tag.Get("parquet")), Type: glueType(field.Type)})
+	}
+	return columns
+}
+
+// parquetName returns the name= of a parquet struct tag
+func parquetName(tag string) string {
+	for _, part := range strings.Split(tag, ",") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(part), "name="); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// glueType maps the Go type of a row field to its Glue column type
+func glueType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int32:
+		return "int"
+	case reflect.Int64:
+		return "bigint"
+	case reflect.Pointer:
+		// The only optional column is the TIMESTAMP_MILLIS crawled_at
+		return "timestamp"
+	case reflect.Slice:
+		return "array<" + glueType(t.Elem()) + ">"
+	case reflect.Struct:
+		var fields []string
+		for i := 0; i < t.NumField(); i++ {
+			fields = append(fields, parquetName(t.Field(i).Tag.Get("parquet"))+":"+glueType(t.Field(i).Type))
+		}
+		return "struct<" + strings.Join(fields, ",") + ">"
+	}
+	return t.String()
+}
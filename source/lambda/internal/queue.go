@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Queue is a message queue keyword requests are received from and acknowledged on.
+// SQSQueue is the production implementation and MemoryQueue the in-memory fake.
+type Queue interface {
+	// Receive returns up to a batch of visible messages, hiding them from other consumers
+	Receive(ctx context.Context) ([]Message, error)
+
+	// Delete removes processed messages and returns the ones that could not be deleted
+	Delete(ctx context.Context, messages []Message) []Message
+
+	// ExtendVisibility keeps a received message hidden for timeout from now.
+	// A zero timeout makes it visible again immediately.
+	ExtendVisibility(ctx context.Context, message Message, timeout time.Duration) error
+
+	// Send enqueues a new message body
+	Send(ctx context.Context, body string) error
+}
+
+// maxDeleteBatch is the most entries SQS accepts in one DeleteMessageBatch call
+const maxDeleteBatch = 10
+
+// SQSQueue is a Queue backed by an SQS queue
+type SQSQueue struct {
+	client sqsiface.SQSAPI
+	url    string
+
+	// Receive settings
+	MaxMessages       int64
+	WaitTime          time.Duration
+	VisibilityTimeout time.Duration
+}
+
+// NewSQSQueue returns a Queue for the SQS queue at url
+func NewSQSQueue(client sqsiface.SQSAPI, url string) *SQSQueue {
+	return &SQSQueue{
+		client:            client,
+		url:               url,
+		MaxMessages:       10,
+		WaitTime:          2 * time.Second,
+		VisibilityTimeout: 5 * time.Second,
+	}
+}
+
+// Receive long-polls the queue for up to MaxMessages messages
+func (q *SQSQueue) Receive(ctx context.Context) ([]Message, error) {
+	resp, err := q.client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.url),
+		MaxNumberOfMessages: aws.Int64(q.MaxMessages),
+		WaitTimeSeconds:     aws.Int64(int64(q.WaitTime / time.Second)),
+		VisibilityTimeout:   aws.Int64(int64(q.VisibilityTimeout / time.Second)),
+		AttributeNames:      aws.StringSlice([]string{sqs.MessageSystemAttributeNameApproximateReceiveCount}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(resp.Messages))
+	for _, m := range resp.Messages {
+		messages = append(messages, Message{
+			ID:            aws.StringValue(m.MessageId),
+			Body:          aws.StringValue(m.Body),
+			ReceiptHandle: aws.StringValue(m.ReceiptHandle),
+			ReceiveCount:  parseReceiveCount(aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount])),
+		})
+	}
+	return messages, nil
+}
+
+// Delete removes messages with DeleteMessageBatch. Entries that fail are
+// retried once on their own batch, unless the failure is a sender fault; the
+// messages that still could not be deleted are returned (SQS will redeliver
+// them).
+func (q *SQSQueue) Delete(ctx context.Context, messages []Message) []Message {
+	failed, rejected := q.deleteBatches(ctx, messages)
+	if len(failed) > 0 {
+		var rejectedAgain []Message
+		failed, rejectedAgain = q.deleteBatches(ctx, failed)
+		rejected = append(rejected, rejectedAgain...)
+	}
+	return append(failed, rejected...)
+}
+
+// deleteBatches deletes messages in chunks of maxDeleteBatch and returns
+// those that failed: the ones worth another try, and the ones rejected with a
+// sender fault (e.g. an expired receipt handle), which would fail again
+func (q *SQSQueue) deleteBatches(ctx context.Context, messages []Message) (failed, rejected []Message) {
+	for start := 0; start < len(messages); start += maxDeleteBatch {
+		chunk := messages[start:min(start+maxDeleteBatch, len(messages))]
+
+		entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(chunk))
+		byID := make(map[string]Message, len(chunk))
+		for i, m := range chunk {
+			// Entry IDs only need to be unique within the batch
+			id := strconv.Itoa(i)
+			entries[i] = &sqs.DeleteMessageBatchRequestEntry{Id: aws.String(id), ReceiptHandle: aws.String(m.ReceiptHandle)}
+			byID[id] = m
+		}
+
+		resp, err := q.client.DeleteMessageBatchWithContext(ctx, &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(q.url),
+			Entries:  entries,
+		})
+		if err != nil {
+			log.Printf("😡 [ERROR] Failed to delete message batch: %v", err)
+			failed = append(failed, chunk...)
+			continue
+		}
+
+		for _, entry := range resp.Failed {
+			m := byID[aws.StringValue(entry.Id)]
+			log.Printf("😡 [ERROR] Failed to delete message %s: %s %s", m.ID, aws.StringValue(entry.Code), aws.StringValue(entry.Message))
+			if aws.BoolValue(entry.SenderFault) {
+				rejected = append(rejected, m)
+			} else {
+				failed = append(failed, m)
+			}
+		}
+	}
+	return failed, rejected
+}
+
+// ExtendVisibility calls ChangeMessageVisibility for the message
+func (q *SQSQueue) ExtendVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	_, err := q.client.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.url),
+		ReceiptHandle:     aws.String(message.ReceiptHandle),
+		VisibilityTimeout: aws.Int64(int64(timeout / time.Second)),
+	})
+	return err
+}
+
+// Send enqueues a new message
+func (q *SQSQueue) Send(ctx context.Context, body string) error {
+	_, err := q.client.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(body),
+	})
+	return err
+}
+
+// MessageFromEvent converts a record delivered by an SQS event source mapping
+func MessageFromEvent(record events.SQSMessage) Message {
+	return Message{
+		ID:            record.MessageId,
+		Body:          record.Body,
+		ReceiptHandle: record.ReceiptHandle,
+		ReceiveCount:  parseReceiveCount(record.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]),
+	}
+}
+
+// checkEventSource returns an error unless arn, the event source ARN of a
+// record (arn:aws:sqs:region:account:name), is the queue queueURL addresses.
+// Only the account and name are compared, since the URL's host depends on
+// the endpoint (e.g. ElasticMQ).
+func checkEventSource(arn, queueURL string) error {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sqs" {
+		return fmt.Errorf("event source %q is not an SQS queue ARN", arn)
+	}
+	u, err := url.Parse(queueURL)
+	if err != nil || strings.Trim(u.Path, "/") != parts[4]+"/"+parts[5] {
+		return fmt.Errorf("event source %s is not the queue %s retries and visibility extensions are sent to", arn, queueURL)
+	}
+	return nil
+}
+
+// parseReceiveCount reads an ApproximateReceiveCount attribute value, defaulting to 1
+func parseReceiveCount(value string) int {
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return 1
+	}
+	return count
+}
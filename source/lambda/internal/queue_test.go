@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// deleteFailingSQS fails the deletes of some receipt handles: sender faults
+// always, the others only on the first try
+type deleteFailingSQS struct {
+	sqsiface.SQSAPI
+	senderFaults map[string]bool
+	flaky        map[string]bool
+	calls        int
+}
+
+func (c *deleteFailingSQS) DeleteMessageBatchWithContext(_ aws.Context, in *sqs.DeleteMessageBatchInput, _ ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	c.calls++
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range in.Entries {
+		handle := aws.StringValue(entry.ReceiptHandle)
+		switch {
+		case c.senderFaults[handle]:
+			out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("ReceiptHandleIsInvalid"), SenderFault: aws.Bool(true)})
+		case c.flaky[handle]:
+			delete(c.flaky, handle)
+			out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError"), SenderFault: aws.Bool(false)})
+		default:
+			out.Successful = append(out.Successful, &sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+		}
+	}
+	return out, nil
+}
+
+func TestSQSQueueDeleteReturnsSenderFaults(t *testing.T) {
+	client := &deleteFailingSQS{senderFaults: map[string]bool{"expired": true}, flaky: map[string]bool{"flaky": true}}
+	queue := NewSQSQueue(client, "https://sqs.example/queue")
+
+	failed := queue.Delete(context.Background(), []Message{
+		{ID: "a", ReceiptHandle: "ok"},
+		{ID: "b", ReceiptHandle: "expired"},
+		{ID: "c", ReceiptHandle: "flaky"},
+	})
+
+	// The flaky entry is deleted on the retry; the sender fault is not
+	// retried but still reported, since the message stays on the queue
+	if ids := messageIDs(failed); len(ids) != 1 || ids[0] != "b" {
+		t.Errorf("got %v not deleted, want b", ids)
+	}
+	if client.calls != 2 {
+		t.Errorf("got %d batch calls, want 2", client.calls)
+	}
+}
+
+func TestCheckEventSource(t *testing.T) {
+	const arn = "arn:aws:sqs:ap-northeast-2:123456789012:keywords"
+	for _, tc := range []struct {
+		arn, queueURL string
+		ok            bool
+	}{
+		{arn, "https://sqs.ap-northeast-2.amazonaws.com/123456789012/keywords", true},
+		{arn, "http://localhost:9324/123456789012/keywords", true},
+		{arn, "https://sqs.ap-northeast-2.amazonaws.com/123456789012/keywords-dlq", false},
+		{arn, "https://sqs.ap-northeast-2.amazonaws.com/210987654321/keywords", false},
+		{"", "https://sqs.ap-northeast-2.amazonaws.com/123456789012/keywords", false},
+		{"arn:aws:sns:ap-northeast-2:123456789012:keywords", "https://sqs.ap-northeast-2.amazonaws.com/123456789012/keywords", false},
+	} {
+		if err := checkEventSource(tc.arn, tc.queueURL); (err == nil) != tc.ok {
+			t.Errorf("checkEventSource(%q, %q) = %v, want ok %v", tc.arn, tc.queueURL, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// RateLimit caps the requests sent to each Naver host: a token bucket per
+// host refills at RequestsPerSecond and holds at most Burst tokens. A zero
+// RequestsPerSecond turns the limit off.
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// outboundLimiter paces every page request, whichever device's client sends
+// it, so the request rate does not grow with the crawl concurrency
+var outboundLimiter = NewHostRateLimiter(RateLimit{})
+
+// ApplyRateLimit sets the limit of the requests sent to Naver from cfg
+func ApplyRateLimit(cfg Config) {
+	outboundLimiter.SetLimit(cfg.RateLimit)
+	if cfg.RateLimit.RequestsPerSecond > 0 {
+		log.Printf("🚦 Rate limit: %g requests per second per host, burst %d", cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+	}
+}
+
+// HostRateLimiter keeps a token bucket per host, safe for concurrent use
+type HostRateLimiter struct {
+	mu      sync.Mutex
+	limit   RateLimit
+	buckets map[string]*tokenBucket
+
+	// now is the clock, replaced in tests
+	now func() time.Time
+}
+
+// tokenBucket holds the tokens left for a host when they were last counted.
+// Tokens go negative when requests are waiting for them.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewHostRateLimiter(limit RateLimit) *HostRateLimiter {
+	return &HostRateLimiter{limit: limit, buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+// SetLimit replaces the limit; the hosts start again with full buckets
+func (l *HostRateLimiter) SetLimit(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.buckets = map[string]*tokenBucket{}
+}
+
+// Wait blocks until a request to host is allowed or ctx is done, and returns
+// ctx's error in the latter case. Waits are published as RateLimitWait.
+func (l *HostRateLimiter) Wait(ctx context.Context, host string) error {
+	delay, ok := l.reserve(host)
+	if !ok || delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		l.cancel(host)
+		return ctx.Err()
+	case <-timer.C:
+	}
+	emitMetric("RateLimitWait", float64(delay.Milliseconds()), UnitMilliseconds, map[string]string{"Host": host})
+	return nil
+}
+
+// reserve takes a token for host and returns how long to wait until it is
+// due; ok is false when there is no limit
+func (l *HostRateLimiter) reserve(host string) (delay time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit.RequestsPerSecond <= 0 {
+		return 0, false
+	}
+
+	now := l.now()
+	burst := float64(max(l.limit.Burst, 1))
+	bucket, found := l.buckets[host]
+	if !found {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[host] = bucket
+		// The limit is published once per host and container, so dashboards
+		// can set the waits against it
+		emitMetric("RateLimit", l.limit.RequestsPerSecond, UnitCountPerSecond, map[string]string{"Host": host})
+	}
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.limit.RequestsPerSecond)
+	bucket.last = now
+
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-bucket.tokens / l.limit.RequestsPerSecond * float64(time.Second)), true
+}
+
+// cancel hands back the token of a request that gave up waiting
+func (l *HostRateLimiter) cancel(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bucket, ok := l.buckets[host]; ok {
+		bucket.tokens = min(float64(max(l.limit.Burst, 1)), bucket.tokens+1)
+	}
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHostRateLimiterPacesEachHost(t *testing.T) {
+	limiter := NewHostRateLimiter(RateLimit{RequestsPerSecond: 10, Burst: 2})
+	now := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+	limiter.now = func() time.Time { return now }
+
+	// The burst goes out at once, then requests are spaced 100ms apart
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if delay, _ := limiter.reserve("search.naver.com"); delay != want {
+			t.Errorf("request %d: got delay %s, want %s", i+1, delay, want)
+		}
+	}
+
+	// Another host has its own bucket, whichever client sends the request
+	if delay, _ := limiter.reserve("m.search.naver.com"); delay != 0 {
+		t.Errorf("got delay %s for another host, want none", delay)
+	}
+
+	// The bucket refills over time but never beyond the burst
+	now = now.Add(time.Hour)
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond} {
+		if delay, _ := limiter.reserve("search.naver.com"); delay != want {
+			t.Errorf("request %d after an hour: got delay %s, want %s", i+1, delay, want)
+		}
+	}
+}
+
+func TestHostRateLimiterWait(t *testing.T) {
+	limiter := NewHostRateLimiter(RateLimit{})
+	if err := limiter.Wait(context.Background(), "search.naver.com"); err != nil {
+		t.Fatalf("got %v without a limit", err)
+	}
+
+	limiter.SetLimit(RateLimit{RequestsPerSecond: 1, Burst: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "search.naver.com"); err != nil {
+		t.Fatalf("got %v for the first request", err)
+	}
+	// The next token is a second away, past the deadline
+	if err := limiter.Wait(ctx, "search.naver.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context's deadline", err)
+	}
+	// The abandoned request handed its token back
+	if delay, _ := limiter.reserve("search.naver.com"); delay > time.Second {
+		t.Errorf("got delay %s, want at most a second", delay)
+	}
+}
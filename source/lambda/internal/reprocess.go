@@ -0,0 +1,361 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// maxDeleteObjects is the most keys S3 accepts in one DeleteObjects call
+const maxDeleteObjects = 1000
+
+// ReprocessStats summarises a reprocessing run
+type ReprocessStats struct {
+	Hours    int // partitions that had archived pages
+	Pages    int // archived pages extracted
+	Failed   int // pages that were unreadable or failed extraction
+	Blocked  int // captcha or blocked pages, which have no results to extract
+	Results  int // ads written
+	Replaced int // previous output objects deleted
+}
+
+// Reprocessor re-runs the current extractors over archived result pages and
+// rewrites the outputs of the affected hours
+type Reprocessor struct {
+	client s3iface.S3API
+	bucket string
+
+	// DryRun extracts and reports without writing or deleting anything
+	DryRun bool
+
+	// Force replaces an hour's outputs even when some of its pages failed or
+	// the archive lacks crawls the outputs hold, dropping their records
+	Force bool
+
+	// Encoder encodes the rewritten outputs (default CSVEncoder)
+	Encoder Encoder
+}
+
+// NewReprocessor returns a Reprocessor for the archive and outputs in bucket
+func NewReprocessor(client s3iface.S3API, bucket string) *Reprocessor {
+	return &Reprocessor{client: client, bucket: bucket, Encoder: CSVEncoder{}}
+}
+
+// Reprocess rewrites every hour from from to to, both inclusive
+func (r *Reprocessor) Reprocess(ctx context.Context, from, to time.Time) (ReprocessStats, error) {
+	var total ReprocessStats
+	for hour := from.Truncate(time.Hour); !hour.After(to); hour = hour.Add(time.Hour) {
+		stats, err := r.ReprocessHour(ctx, hour)
+		total.Hours += stats.Hours
+		total.Pages += stats.Pages
+		total.Failed += stats.Failed
+		total.Blocked += stats.Blocked
+		total.Results += stats.Results
+		total.Replaced += stats.Replaced
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", partitionPath(hour), err)
+		}
+	}
+	return total, nil
+}
+
+// ReprocessHour extracts every page archived in hour's partition and replaces
+// the partition's output objects with one object per table holding the new
+// records. Hours without archived pages are left untouched, since their
+// outputs could not be rebuilt. Unless Force is set, so is an hour with a page
+// that failed extraction, as dropping its records could wipe good data because
+// of a selector bug or a corrupt archive object, and an hour whose outputs
+// hold a request_id none of the archived pages has: archival is best effort,
+// so the rows of a crawl whose pages were not archived would be lost. Blocked
+// pages had no results in the first place and do not count as failures.
+func (r *Reprocessor) ReprocessHour(ctx context.Context, hour time.Time) (ReprocessStats, error) {
+	var stats ReprocessStats
+	partition := partitionPath(hour)
+
+	pages, err := listKeys(ctx, r.client, r.bucket, archivePrefix+partition+"/")
+	if err != nil {
+		return stats, fmt.Errorf("failed to list archived pages: %w", err)
+	}
+	if len(pages) == 0 {
+		return stats, nil
+	}
+	stats.Hours = 1
+
+	var merged PageResult
+	archived := map[string]bool{}
+	crawls, failed := groupArchivedPages(pages)
+	for _, key := range failed {
+		log.Printf("⚠️ %s: not an archive key", key)
+		stats.Pages++
+		stats.Failed++
+	}
+	for _, crawl := range crawls {
+		var pager adPager
+		for _, key := range crawl {
+			page, meta, err := r.extractArchived(ctx, key, hour)
+			stats.Pages++
+			if meta.RequestID != "" {
+				archived[meta.RequestID] = true
+			}
+			if errors.Is(err, ErrBlocked) {
+				stats.Blocked++
+				continue
+			}
+			if err != nil {
+				log.Printf("⚠️ %s: %v", key.key, err)
+				stats.Failed++
+				continue
+			}
+			pager.add(page.Ads, key.page)
+			merged.Organic = append(merged.Organic, page.Organic...)
+			merged.Sections = append(merged.Sections, page.Sections...)
+		}
+		merged.Ads = append(merged.Ads, pager.ads...)
+	}
+	stats.Results = len(merged.Ads)
+	if stats.Failed > 0 && !r.Force {
+		return stats, fmt.Errorf("%d of %d archived pages failed, outputs left in place", stats.Failed, stats.Pages)
+	}
+
+	// Only the outputs of the format written are replaced
+	encoder := r.Encoder
+	if encoder == nil {
+		encoder = CSVEncoder{}
+	}
+	var previous []string
+	for _, prefix := range outputPrefixes {
+		keys, err := listKeys(ctx, r.client, r.bucket, encoder.TablePrefix()+prefix+partition+"/")
+		if err != nil {
+			return stats, fmt.Errorf("failed to list previous outputs: %w", err)
+		}
+		previous = append(previous, keys...)
+	}
+	missing, err := r.unarchivedRequests(ctx, previous, archived)
+	if err != nil {
+		return stats, err
+	}
+	if len(missing) > 0 && !r.Force {
+		return stats, fmt.Errorf("%d crawls in the outputs are not in the archive (%s), outputs left in place",
+			len(missing), strings.Join(missing, ", "))
+	}
+
+	log.Printf("🔁 %s: %d pages, %d results, replacing %d objects", partition, stats.Pages, stats.Results, len(previous))
+	if r.DryRun {
+		return stats, nil
+	}
+
+	// Write before deleting so a failure never leaves the hour empty
+	sink := NewS3ResultSink(r.client, r.bucket)
+	sink.Encoder = encoder
+	if err := sink.WritePage(ctx, hour, merged); err != nil {
+		return stats, err
+	}
+	if err := deleteKeys(ctx, r.client, r.bucket, previous); err != nil {
+		return stats, fmt.Errorf("failed to delete previous outputs: %w", err)
+	}
+	stats.Replaced = len(previous)
+	return stats, nil
+}
+
+// archivedPage is an archive key with the crawl it belongs to
+type archivedPage struct {
+	key     string
+	device  string
+	keyword string
+	page    int
+}
+
+// groupArchivedPages groups archive keys by crawl (device and keyword), each
+// crawl's pages in page order. Keys that do not parse are returned apart.
+func groupArchivedPages(keys []string) (crawls [][]archivedPage, invalid []string) {
+	index := map[string]int{}
+	for _, key := range keys {
+		device, keyword, page, err := parseArchiveKey(key)
+		if err != nil {
+			invalid = append(invalid, key)
+			continue
+		}
+		id := device + "/" + keyword
+		i, ok := index[id]
+		if !ok {
+			i = len(crawls)
+			index[id] = i
+			crawls = append(crawls, nil)
+		}
+		crawls[i] = append(crawls[i], archivedPage{key: key, device: device, keyword: keyword, page: page})
+	}
+	for _, crawl := range crawls {
+		sort.Slice(crawl, func(a, b int) bool { return crawl[a].page < crawl[b].page })
+	}
+	return crawls, invalid
+}
+
+// extractArchived runs the current selectors for the page's device over an
+// archived page; ad list pages only yield ads. The records get the crawl
+// metadata archived with the page, which is returned even when extraction
+// fails; pages archived without it are stamped with the start of their hour.
+func (r *Reprocessor) extractArchived(ctx context.Context, archived archivedPage, hour time.Time) (PageResult, CrawlMeta, error) {
+	profile, ok := profileFor(archived.device)
+	if !ok {
+		return PageResult{}, CrawlMeta{}, fmt.Errorf("unknown device: %q", archived.device)
+	}
+	selectors, err := selectorsFor(profile)
+	if err != nil {
+		return PageResult{}, CrawlMeta{}, err
+	}
+
+	body, meta, err := readArchivedPage(ctx, r.client, r.bucket, archived.key)
+	if err != nil {
+		return PageResult{}, CrawlMeta{}, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return PageResult{}, meta, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	var page PageResult
+	if archived.page > 1 {
+		page.Ads, err = extractResults(doc, archived.keyword, profile.Device, selectors.adListSelectors())
+	} else {
+		page, err = extractPage(doc, archived.keyword, profile.Device, selectors)
+	}
+	if err != nil {
+		return PageResult{}, meta, err
+	}
+	if meta.CrawledAt.IsZero() {
+		meta.CrawledAt = hour.UTC()
+	}
+	page.stamp(meta)
+	return page, meta, nil
+}
+
+// unarchivedRequests returns the request_ids in the output objects keys that
+// are not in archived, sorted. Rows without a request_id, written before the
+// column existed, cannot be matched to a page and are reported as "(none)".
+func (r *Reprocessor) unarchivedRequests(ctx context.Context, keys []string, archived map[string]bool) ([]string, error) {
+	missing := map[string]bool{}
+	for _, key := range keys {
+		out, err := r.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(r.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous output %s: %w", key, err)
+		}
+		data, err := io.ReadAll(out.Body)
+		out.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous output %s: %w", key, err)
+		}
+		ids, err := outputRequestIDs(key, data)
+		if err != nil {
+			return nil, fmt.Errorf("previous output %s: %w", key, err)
+		}
+		for _, id := range ids {
+			if id == "" {
+				missing["(none)"] = true
+			} else if !archived[id] {
+				missing[id] = true
+			}
+		}
+	}
+	ids := make([]string, 0, len(missing))
+	for id := range missing {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// requestIDRow reads the request_id column of any output table
+type requestIDRow struct {
+	RequestID string `parquet:"name=request_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// outputRequestIDs returns the request_id of every row of an output object,
+// in either format; a CSV written before the column existed yields empty ids
+func outputRequestIDs(key string, data []byte) ([]string, error) {
+	switch {
+	case strings.HasSuffix(key, CSVEncoder{}.Extension()):
+		gzReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gzReader.Close()
+		records, err := csv.NewReader(gzReader).ReadAll()
+		if err != nil || len(records) == 0 {
+			return nil, err
+		}
+		column := slices.Index(records[0], "request_id")
+		ids := make([]string, len(records)-1)
+		for i, record := range records[1:] {
+			if column >= 0 && column < len(record) {
+				ids[i] = record[column]
+			}
+		}
+		return ids, nil
+	case strings.HasSuffix(key, ParquetEncoder{}.Extension()):
+		file, err := buffer.NewBufferFile(data)
+		if err != nil {
+			return nil, err
+		}
+		pr, err := reader.NewParquetReader(file, new(requestIDRow), parquetParallelism)
+		if err != nil {
+			return nil, err
+		}
+		defer pr.ReadStop()
+		rows := make([]requestIDRow, pr.GetNumRows())
+		if err := pr.Read(&rows); err != nil {
+			return nil, err
+		}
+		ids := make([]string, len(rows))
+		for i, row := range rows {
+			ids[i] = row.RequestID
+		}
+		return ids, nil
+	}
+	return nil, fmt.Errorf("unknown output format")
+}
+
+// deleteKeys deletes keys in batches of maxDeleteObjects
+func deleteKeys(ctx context.Context, client s3iface.S3API, bucket string, keys []string) error {
+	for start := 0; start < len(keys); start += maxDeleteObjects {
+		chunk := keys[start:min(start+maxDeleteObjects, len(keys))]
+		objects := make([]*s3.ObjectIdentifier, len(chunk))
+		for i, key := range chunk {
+			objects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		out, err := client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			var failed []string
+			for _, e := range out.Errors {
+				failed = append(failed, fmt.Sprintf("%s (%s)", aws.StringValue(e.Key), aws.StringValue(e.Code)))
+			}
+			return errors.New(strings.Join(failed, ", "))
+		}
+	}
+	return nil
+}
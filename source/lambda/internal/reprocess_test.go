@@ -0,0 +1,368 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// memS3 implements the S3 calls used by the archive and reprocessor
+type memS3 struct {
+	s3iface.S3API
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	metadata map[string]map[string]*string
+}
+
+func newMemS3() *memS3 {
+	return &memS3{objects: map[string][]byte{}, metadata: map[string]map[string]*string{}}
+}
+
+func (m *memS3) PutObjectWithContext(_ aws.Context, in *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[aws.StringValue(in.Key)] = body
+	m.metadata[aws.StringValue(in.Key)] = in.Metadata
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *memS3) GetObjectWithContext(_ aws.Context, in *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := aws.StringValue(in.Key)
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(m.objects[key])), Metadata: m.metadata[key]}, nil
+}
+
+func (m *memS3) ListObjectsV2PagesWithContext(_ aws.Context, in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	page := &s3.ListObjectsV2Output{}
+	for _, key := range m.keysLocked(aws.StringValue(in.Prefix)) {
+		page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	fn(page, true)
+	return nil
+}
+
+func (m *memS3) DeleteObjectsWithContext(_ aws.Context, in *s3.DeleteObjectsInput, _ ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, object := range in.Delete.Objects {
+		delete(m.objects, aws.StringValue(object.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (m *memS3) keys(prefix string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keysLocked(prefix)
+}
+
+func (m *memS3) keysLocked(prefix string) []string {
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestArchiveKeyRoundTrip(t *testing.T) {
+	fetchedAt := time.Date(2025, 8, 13, 0, 30, 0, 0, time.UTC)
+	for _, page := range []RawPage{
+		{Keyword: "50/50 할인 & 이벤트", Device: DeviceMobile, CrawlMeta: CrawlMeta{CrawledAt: fetchedAt}},
+		{Keyword: "50/50 할인 & 이벤트", Device: DeviceMobile, Page: 1, CrawlMeta: CrawlMeta{CrawledAt: fetchedAt}},
+		{Keyword: "page=3", Device: DeviceMobile, Page: 3, CrawlMeta: CrawlMeta{CrawledAt: fetchedAt}},
+	} {
+		key := archiveKey(page)
+		if want := "raw/basic_date=20250813/hh=9/device=MO/"; !strings.HasPrefix(key, want) {
+			t.Errorf("key %q does not start with %q", key, want)
+		}
+
+		// An ad list page received after the hour ended stays with its result page
+		page.Partition = fetchedAt
+		page.CrawledAt = fetchedAt.Add(time.Hour)
+		if later := archiveKey(page); later != key {
+			t.Errorf("got key %q for a page received an hour later, want %q", later, key)
+		}
+
+		device, keyword, n, err := parseArchiveKey(key)
+		if err != nil || device != page.Device || keyword != page.Keyword || n != max(page.Page, 1) {
+			t.Errorf("parseArchiveKey(%q) = %q, %q, %d, %v", key, device, keyword, n, err)
+		}
+	}
+}
+
+func TestArchivedPageKeepsCrawlMeta(t *testing.T) {
+	client := newMemS3()
+	ctx := context.Background()
+	meta := CrawlMeta{
+		CrawledAt:       time.Date(2025, 8, 13, 0, 30, 15, 250, time.UTC),
+		RequestID:       "req-1",
+		UserAgent:       "Mozilla/5.0 (test)",
+		HTTPStatus:      200,
+		LatencyMs:       321,
+		LambdaRequestID: "lambda-1",
+	}
+	raw := RawPage{Keyword: "스마트폰", Device: DeviceDesktop, CrawlMeta: meta, Body: []byte("<html></html>")}
+	if err := NewS3PageArchive(client, "bucket").StorePage(ctx, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	body, got, err := readArchivedPage(ctx, client, "bucket", archiveKey(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != string(raw.Body) || got != meta {
+		t.Errorf("got %q, %+v; want %q, %+v", body, got, raw.Body, meta)
+	}
+}
+
+// archivedFixture is a SERP fixture archived as a device's result page for keyword
+type archivedFixture struct{ device, keyword, fixture string }
+
+// archiveFixtures archives the fixtures as pages crawled in hour
+func archiveFixtures(t *testing.T, client *memS3, hour time.Time, fixtures ...archivedFixture) {
+	t.Helper()
+	archive := NewS3PageArchive(client, "bucket")
+	for _, page := range fixtures {
+		body, err := os.ReadFile(filepath.Join("testdata", "serp", page.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := CrawlMeta{CrawledAt: hour.Add(10 * time.Minute), RequestID: "crawl-" + page.device}
+		raw := RawPage{Keyword: page.keyword, Device: page.device, CrawlMeta: meta, Body: body}
+		if err := archive.StorePage(context.Background(), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeOutput stores an ads output object with a row per request id, encoded
+// in the format of the key's extension
+func writeOutput(t *testing.T, client *memS3, key string, requestIDs ...string) {
+	t.Helper()
+	var encoder Encoder = CSVEncoder{}
+	if strings.HasSuffix(key, ParquetEncoder{}.Extension()) {
+		encoder = ParquetEncoder{}
+	}
+	ads := make([]SearchResult, len(requestIDs))
+	for i, id := range requestIDs {
+		ads[i] = SearchResult{Query: "스마트폰", Device: DeviceDesktop, Rank: i + 1, CrawlMeta: CrawlMeta{RequestID: id}}
+	}
+	data, err := encoder.EncodeAds(ads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.objects[key] = data
+}
+
+func TestReprocessHourRewritesOutputs(t *testing.T) {
+	client := newMemS3()
+	ctx := context.Background()
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+
+	archiveFixtures(t, client, hour,
+		archivedFixture{DeviceDesktop, "스마트폰", "pc/ads_present.html"},
+		archivedFixture{DeviceMobile, "스마트폰", "mo/multi_part_title.html"},
+		archivedFixture{DeviceMobile, "차단", "mo/captcha.html"},
+	)
+
+	partition := resultPrefix + partitionPath(hour) + "/"
+	writeOutput(t, client, partition+"stale.csv.gz", "crawl-PC", "crawl-MO")
+	client.objects[resultPrefix+partitionPath(hour.Add(time.Hour))+"/other.csv.gz"] = []byte("next hour")
+
+	stats, err := NewReprocessor(client, "bucket").ReprocessHour(ctx, hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ReprocessStats{Hours: 1, Pages: 3, Blocked: 1, Results: 4, Replaced: 1}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+
+	outputs := client.keys(partition)
+	if len(outputs) != 1 || strings.HasSuffix(outputs[0], "stale.csv.gz") {
+		t.Fatalf("got outputs %v, want one new object", outputs)
+	}
+	if n := len(client.keys(resultPrefix)); n != 2 {
+		t.Errorf("got %d outputs in total, want the next hour's object kept", n)
+	}
+
+	gzReader, err := gzip.NewReader(bytes.NewReader(client.objects[outputs[0]]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(gzReader).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1+want.Results {
+		t.Fatalf("got %d CSV rows, want header and %d results", len(records), want.Results)
+	}
+
+	// Rows keep the metadata of the crawl they were archived from
+	column := slices.Index(records[0], "request_id")
+	for _, record := range records[1:] {
+		if want := "crawl-" + record[1]; column < 0 || record[column] != want {
+			t.Errorf("row %v: want request_id %q", record, want)
+		}
+	}
+}
+
+func TestReprocessHourWithoutArchiveKeepsOutputs(t *testing.T) {
+	client := newMemS3()
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+	key := resultPrefix + partitionPath(hour) + "/existing.csv.gz"
+	client.objects[key] = []byte("kept")
+
+	stats, err := NewReprocessor(client, "bucket").ReprocessHour(context.Background(), hour)
+	if err != nil || stats != (ReprocessStats{}) {
+		t.Fatalf("got %+v, %v; want nothing done", stats, err)
+	}
+	if _, ok := client.objects[key]; !ok {
+		t.Error("existing output was deleted")
+	}
+}
+
+func TestReprocessHourKeepsOutputsWhenAPageFails(t *testing.T) {
+	client := newMemS3()
+	ctx := context.Background()
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+
+	archiveFixtures(t, client, hour,
+		archivedFixture{DeviceDesktop, "스마트폰", "pc/ads_present.html"},
+		archivedFixture{DeviceDesktop, "꽃배달", "pc/layout_changed.html"},
+	)
+	key := resultPrefix + partitionPath(hour) + "/existing.csv.gz"
+	writeOutput(t, client, key, "crawl-PC")
+
+	reprocessor := NewReprocessor(client, "bucket")
+	stats, err := reprocessor.ReprocessHour(ctx, hour)
+	if err == nil {
+		t.Fatal("got no error with a failed page")
+	}
+	if stats.Failed != 1 || stats.Replaced != 0 {
+		t.Errorf("got stats %+v, want 1 failed and nothing replaced", stats)
+	}
+	if outputs := client.keys(resultPrefix); len(outputs) != 1 || outputs[0] != key {
+		t.Fatalf("got outputs %v, want only the existing one", outputs)
+	}
+
+	// Force drops the failed page's records and replaces the outputs anyway
+	reprocessor.Force = true
+	stats, err = reprocessor.ReprocessHour(ctx, hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failed != 1 || stats.Replaced != 1 {
+		t.Errorf("got stats %+v, want 1 failed and 1 replaced", stats)
+	}
+	if _, ok := client.objects[key]; ok {
+		t.Error("existing output was kept with Force")
+	}
+}
+
+func TestReprocessHourKeepsOutputsOfUnarchivedCrawls(t *testing.T) {
+	ctx := context.Background()
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+	partition := partitionPath(hour) + "/"
+
+	for _, encoder := range []Encoder{CSVEncoder{}, ParquetEncoder{}} {
+		client := newMemS3()
+
+		// Only the desktop crawl was archived; the outputs also hold two
+		// crawls whose pages were not
+		archiveFixtures(t, client, hour, archivedFixture{DeviceDesktop, "스마트폰", "pc/ads_present.html"})
+		existing := []string{
+			encoder.TablePrefix() + resultPrefix + partition + "a" + encoder.Extension(),
+			encoder.TablePrefix() + organicPrefix + partition + "b" + encoder.Extension(),
+		}
+		writeOutput(t, client, existing[0], "crawl-PC", "unarchived-1")
+		writeOutput(t, client, existing[1], "crawl-PC", "unarchived-2")
+
+		// The outputs of the other format are neither checked nor replaced
+		other := "parquet/" + resultPrefix + partition + "c.parquet"
+		if encoder.TablePrefix() != "" {
+			other = resultPrefix + partition + "c.csv.gz"
+		}
+		writeOutput(t, client, other, "unarchived-3")
+
+		reprocessor := NewReprocessor(client, "bucket")
+		reprocessor.Encoder = encoder
+		stats, err := reprocessor.ReprocessHour(ctx, hour)
+		if err == nil || !strings.Contains(err.Error(), "unarchived-1, unarchived-2") {
+			t.Fatalf("%T: got %v, want the unarchived crawls reported", encoder, err)
+		}
+		if stats.Replaced != 0 || len(client.keys(encoder.TablePrefix()+resultPrefix)) != 1 {
+			t.Errorf("%T: got stats %+v and ads outputs %v, want only the existing one", encoder, stats, client.keys(encoder.TablePrefix()+resultPrefix))
+		}
+		for _, key := range existing {
+			if _, ok := client.objects[key]; !ok {
+				t.Errorf("%T: %s was deleted", encoder, key)
+			}
+		}
+
+		// Force replaces them anyway
+		reprocessor.Force = true
+		if stats, err = reprocessor.ReprocessHour(ctx, hour); err != nil || stats.Replaced != 2 {
+			t.Errorf("%T: got %+v, %v; want both outputs replaced", encoder, stats, err)
+		}
+		if _, ok := client.objects[other]; !ok {
+			t.Errorf("%T: %s of the other format was deleted", encoder, other)
+		}
+	}
+}
+
+func TestReprocessHourMergesAdListPages(t *testing.T) {
+	client := newMemS3()
+	ctx := context.Background()
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+
+	// Stored out of page order; the crawl is rebuilt in page order
+	archive := NewS3PageArchive(client, "bucket")
+	for page, fixture := range map[int]string{3: "paginated_adlist2", 1: "paginated", 2: "paginated_adlist1"} {
+		body, err := os.ReadFile(filepath.Join("testdata", "serp", "pc", fixture+".html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := RawPage{Keyword: "꽃배달", Device: DeviceDesktop, Page: page, CrawlMeta: CrawlMeta{CrawledAt: hour}, Body: body}
+		if err := archive.StorePage(ctx, raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reprocessor := NewReprocessor(client, "bucket")
+	reprocessor.DryRun = true
+	stats, err := reprocessor.ReprocessHour(ctx, hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 ads on the result page, 1 new on the first ad list page and 2 on the second
+	if want := (ReprocessStats{Hours: 1, Pages: 3, Results: 5}); stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+}
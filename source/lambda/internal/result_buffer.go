@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// BatchLimits bound a batch of buffered results. A batch that reaches either
+// limit is written at the next commit; zero means no limit, so the batch is
+// only written when the buffer is flushed.
+type BatchLimits struct {
+	// MaxCrawls is the most keyword crawls a batch holds
+	MaxCrawls int
+
+	// MaxRecords is the most records (ads, organic results and sections) a batch holds
+	MaxRecords int
+}
+
+// batchKey identifies a batch: one device in one partition hour
+type batchKey struct {
+	device string
+	hour   time.Time
+}
+
+// resultBatch is the merged results of the crawls buffered for one batchKey
+type resultBatch struct {
+	page     PageResult
+	crawls   int
+	messages map[string]bool
+}
+
+func (b *resultBatch) records() int {
+	return len(b.page.Ads) + len(b.page.Organic) + len(b.page.Sections)
+}
+
+// full reports whether b has reached limits
+func (b *resultBatch) full(limits BatchLimits) bool {
+	return (limits.MaxCrawls > 0 && b.crawls >= limits.MaxCrawls) ||
+		(limits.MaxRecords > 0 && b.records() >= limits.MaxRecords)
+}
+
+// ResultBuffer collects crawl results across the messages of an invocation
+// and writes them as one page per device and partition hour, so an hour gets
+// a few large objects instead of one per keyword. Finished messages are held
+// until every batch holding their results has been written, and only then
+// released for deletion; a message whose results could not be written is
+// released as lost, for the caller to make visible again.
+type ResultBuffer struct {
+	sink   ResultSink
+	limits BatchLimits
+
+	// now picks the partition hour of results without a crawl time, and
+	// tells which batches belong to an earlier hour (default time.Now)
+	now func() time.Time
+
+	// flushMu serialises commits and flushes so a message is never released
+	// while a batch holding its results is still being written
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	batches map[batchKey]*resultBatch
+	held    []Message
+	lost    map[string]bool
+}
+
+// NewResultBuffer returns a buffer writing to sink in batches bounded by limits
+func NewResultBuffer(sink ResultSink, limits BatchLimits) *ResultBuffer {
+	return &ResultBuffer{
+		sink:    sink,
+		limits:  limits,
+		now:     time.Now,
+		batches: map[batchKey]*resultBatch{},
+		lost:    map[string]bool{},
+	}
+}
+
+// Add buffers the results of one crawl of message on device, in the hour
+// the crawl's first page was received, which is also the hour its pages are
+// archived under
+func (b *ResultBuffer) Add(message Message, device string, page PageResult) {
+	if len(page.Ads) == 0 && len(page.Organic) == 0 && len(page.Sections) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	crawledAt := page.CrawledAt
+	if crawledAt.IsZero() {
+		crawledAt = b.now()
+	}
+	key := batchKey{device: device, hour: crawledAt.Truncate(time.Hour)}
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &resultBatch{messages: map[string]bool{}}
+		b.batches[key] = batch
+	}
+	batch.page.Ads = append(batch.page.Ads, page.Ads...)
+	batch.page.Organic = append(batch.page.Organic, page.Organic...)
+	batch.page.Sections = append(batch.page.Sections, page.Sections...)
+	batch.crawls++
+	batch.messages[message.ID] = true
+}
+
+// Commit holds finished messages until their results are written, writes
+// the batches that are full or belong to an earlier hour, and returns the
+// held messages whose results are now durable and the ones whose results
+// were lost.
+func (b *ResultBuffer) Commit(ctx context.Context, finished []Message) (durable, lost []Message) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	hour := b.now().Truncate(time.Hour)
+	b.mu.Lock()
+	b.held = append(b.held, finished...)
+	b.mu.Unlock()
+
+	b.write(ctx, func(key batchKey, batch *resultBatch) bool {
+		return batch.full(b.limits) || !key.hour.Equal(hour)
+	})
+	return b.release()
+}
+
+// Flush writes every buffered batch and releases all held messages. It must
+// run before the invocation ends, since a held message is never deleted.
+func (b *ResultBuffer) Flush(ctx context.Context) (durable, lost []Message) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.write(ctx, func(batchKey, *resultBatch) bool { return true })
+	return b.release()
+}
+
+// write takes the batches selected by flush out of the buffer and writes
+// them; the messages of a batch that fails are marked lost
+func (b *ResultBuffer) write(ctx context.Context, flush func(batchKey, *resultBatch) bool) {
+	b.mu.Lock()
+	taken := map[batchKey]*resultBatch{}
+	for key, batch := range b.batches {
+		if flush(key, batch) {
+			taken[key] = batch
+			delete(b.batches, key)
+		}
+	}
+	b.mu.Unlock()
+
+	for key, batch := range taken {
+		if err := b.sink.WritePage(ctx, key.hour, batch.page); err != nil {
+			log.Printf("❌ Failed to upload %d results of %d keywords (%s): %v", batch.records(), batch.crawls, key.device, err)
+			emitMetric("UploadFailed", 1, UnitCount, map[string]string{"Device": key.device})
+			b.mu.Lock()
+			for id := range batch.messages {
+				b.lost[id] = true
+			}
+			b.mu.Unlock()
+			continue
+		}
+		log.Printf("📦 Uploaded %d results of %d keywords (%s, %s)", batch.records(), batch.crawls, key.device, partitionPath(key.hour))
+	}
+}
+
+// release returns the held messages that no buffered batch is waiting on,
+// split by whether their results were written
+func (b *ResultBuffer) release() (durable, lost []Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pending := map[string]bool{}
+	for _, batch := range b.batches {
+		for id := range batch.messages {
+			pending[id] = true
+		}
+	}
+
+	var held []Message
+	for _, m := range b.held {
+		switch {
+		case pending[m.ID]:
+			held = append(held, m)
+		case b.lost[m.ID]:
+			delete(b.lost, m.ID)
+			lost = append(lost, m)
+		default:
+			durable = append(durable, m)
+		}
+	}
+	b.held = held
+	return durable, lost
+}
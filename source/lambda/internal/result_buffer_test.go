@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// bufferedAds returns a page with n ads for keyword
+func bufferedAds(keyword string, n int) PageResult {
+	var page PageResult
+	for i := range n {
+		page.Ads = append(page.Ads, SearchResult{Query: keyword, Rank: i + 1})
+	}
+	return page
+}
+
+func messageIDs(messages []Message) []string {
+	ids := make([]string, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func TestResultBufferWritesFullBatches(t *testing.T) {
+	sink := &MemorySink{}
+	buffer := NewResultBuffer(sink, BatchLimits{MaxCrawls: 2})
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+	buffer.now = func() time.Time { return hour.Add(10 * time.Minute) }
+	ctx := context.Background()
+
+	a, b, c := Message{ID: "a"}, Message{ID: "b"}, Message{ID: "c"}
+	buffer.Add(a, DeviceDesktop, bufferedAds("a", 2))
+	buffer.Add(a, DeviceMobile, bufferedAds("a", 1))
+	buffer.Add(b, DeviceDesktop, bufferedAds("b", 3))
+
+	// The desktop batch is full, but a also waits on the mobile batch
+	durable, lost := buffer.Commit(ctx, []Message{a, b})
+	if ids := messageIDs(durable); len(ids) != 1 || ids[0] != "b" || len(lost) != 0 {
+		t.Fatalf("got durable %v and lost %v, want only b durable", ids, messageIDs(lost))
+	}
+	if sink.Writes() != 1 || len(sink.Results()) != 5 || !sink.Hours()[0].Equal(hour) {
+		t.Fatalf("got %d writes of %d results in %v, want 1 of 5 in %v", sink.Writes(), len(sink.Results()), sink.Hours(), hour)
+	}
+
+	// A crawl without results does not hold its message
+	buffer.Add(c, DeviceDesktop, PageResult{})
+	if durable, _ := buffer.Commit(ctx, []Message{c}); len(durable) != 1 || durable[0].ID != "c" {
+		t.Errorf("got durable %v, want c", messageIDs(durable))
+	}
+
+	durable, lost = buffer.Flush(ctx)
+	if ids := messageIDs(durable); len(ids) != 1 || ids[0] != "a" || len(lost) != 0 {
+		t.Errorf("got durable %v and lost %v after flush, want a", ids, messageIDs(lost))
+	}
+	if sink.Writes() != 2 || len(sink.Results()) != 6 {
+		t.Errorf("got %d writes of %d results, want 2 of 6", sink.Writes(), len(sink.Results()))
+	}
+}
+
+func TestResultBufferSplitsHours(t *testing.T) {
+	sink := &MemorySink{}
+	buffer := NewResultBuffer(sink, BatchLimits{})
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+	now := hour.Add(59 * time.Minute)
+	buffer.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	buffer.Add(Message{ID: "a"}, DeviceDesktop, bufferedAds("a", 1))
+	now = hour.Add(61 * time.Minute)
+	buffer.Add(Message{ID: "b"}, DeviceDesktop, bufferedAds("b", 1))
+
+	// The batch of the past hour is written at the next commit, whatever its size
+	durable, _ := buffer.Commit(ctx, []Message{{ID: "a"}, {ID: "b"}})
+	if ids := messageIDs(durable); len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("got durable %v, want a", ids)
+	}
+	buffer.Flush(ctx)
+	hours := sink.Hours()
+	if len(hours) != 2 || !hours[0].Equal(hour) || !hours[1].Equal(hour.Add(time.Hour)) {
+		t.Errorf("got writes in %v, want %v and the next hour", hours, hour)
+	}
+}
+
+func TestResultBufferPartitionsByCrawlTime(t *testing.T) {
+	sink := &MemorySink{}
+	buffer := NewResultBuffer(sink, BatchLimits{})
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+	buffer.now = func() time.Time { return hour.Add(61 * time.Minute) }
+
+	// A crawl that started before the hour ended stays in that hour
+	page := bufferedAds("a", 1)
+	page.CrawledAt = hour.Add(59 * time.Minute)
+	buffer.Add(Message{ID: "a"}, DeviceDesktop, page)
+	buffer.Flush(context.Background())
+
+	if hours := sink.Hours(); len(hours) != 1 || !hours[0].Equal(hour) {
+		t.Errorf("got writes in %v, want %v", hours, hour)
+	}
+}
+
+func TestResultBufferReleasesLostMessages(t *testing.T) {
+	sink := &MemorySink{Err: errors.New("s3 down")}
+	buffer := NewResultBuffer(sink, BatchLimits{})
+	ctx := context.Background()
+
+	buffer.Add(Message{ID: "a"}, DeviceDesktop, bufferedAds("a", 1))
+	if durable, lost := buffer.Commit(ctx, []Message{{ID: "a"}, {ID: "b"}}); len(durable) != 1 || len(lost) != 0 {
+		t.Fatalf("got durable %v and lost %v, want only b durable", messageIDs(durable), messageIDs(lost))
+	}
+
+	// A failed write is not retried by the buffer: the message is released
+	// as lost for the processor to make visible again
+	durable, lost := buffer.Flush(ctx)
+	if len(durable) != 0 || len(lost) != 1 || lost[0].ID != "a" {
+		t.Errorf("got durable %v and lost %v, want a lost", messageIDs(durable), messageIDs(lost))
+	}
+	if durable, lost := buffer.Flush(ctx); len(durable)+len(lost) != 0 {
+		t.Errorf("got %v and %v released twice", messageIDs(durable), messageIDs(lost))
+	}
+}
@@ -2,96 +2,191 @@ package internal
 
 import (
 	"bytes"
-	"compress/gzip"
-	"encoding/csv"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/google/uuid"
 )
 
-var (
-	s3Client = s3.New(session.Must(session.NewSession(&aws.Config{Region: aws.String("ap-northeast-2")})))
-	bucket   = "skale-crawling-manager"
+// ResultSink stores the results of one crawl. S3ResultSink is the production
+// implementation and MemorySink the in-memory fake.
+type ResultSink interface {
+	// WritePage stores page in the partition of hour
+	WritePage(ctx context.Context, hour time.Time, page PageResult) error
+}
+
+// Key prefixes of the outputs, one table per record type, under the
+// Encoder's TablePrefix
+const (
+	resultPrefix  = "data/"
+	organicPrefix = "organic/"
+	sectionPrefix = "sections/"
 )
 
-func uploadResult(result []SearchResult, keyword string) {
-	if len(result) == 0 {
-		log.Println("🚫 No results to upload. Skipping S3 upload.")
-		return
-	}
+// outputPrefixes lists every output table, e.g. for rewriting an hour
+var outputPrefixes = []string{resultPrefix, organicPrefix, sectionPrefix}
 
-	loc, err := time.LoadLocation("Asia/Seoul")
-	if err != nil {
-		panic(err)
-	}
+// kst is the timezone partitions are cut in (Korea has no DST)
+var kst = time.FixedZone("KST", 9*60*60)
+
+// partitionPath returns the basic_date=YYYYMMDD/hh=H partition t falls in.
+// Raw page archives mirror the output partitions so an hour can be reprocessed.
+func partitionPath(t time.Time) string {
+	t = t.In(kst)
+	return fmt.Sprintf("basic_date=%s/hh=%d", t.Format("20060102"), t.Hour())
+}
 
-	now := time.Now().In(loc)
+// Encoder serialises the tables of a page for upload. CSVEncoder is the
+// default; ParquetEncoder writes typed, snappy-compressed Parquet.
+type Encoder interface {
+	// Extension is the suffix of the object names, e.g. ".csv.gz"
+	Extension() string
 
-	basicDate := now.Format("20060102")
-	hour := now.Hour()
-	uuid := uuid.New().String()[:18]
+	// ContentType is the Content-Type of the objects
+	ContentType() string
 
-	key := fmt.Sprintf("data/basic_date=%s/hh=%d/%s.csv.gz", basicDate, hour, uuid)
+	// TablePrefix is prepended to the output prefixes (data/, ...). A Glue
+	// table reads a single format, so each format has prefixes of its own.
+	TablePrefix() string
 
-	buffer := new(bytes.Buffer)
-	gzWriter := gzip.NewWriter(buffer)
-	csvWriter := csv.NewWriter(gzWriter)
+	EncodeAds(ads []SearchResult) ([]byte, error)
+	EncodeOrganic(results []OrganicResult) ([]byte, error)
+	EncodeSections(sections []SerpSection) ([]byte, error)
+}
 
-	// CSV 헤더 작성
-	header := []string{"query", "device", "rank", "site_name", "display_url", "title", "description"}
-	if err := csvWriter.Write(header); err != nil {
-		log.Printf("❌ Failed to write CSV header: %v", err)
-		return
+// Output formats selectable with ResultFormatEnv
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// NewEncoder returns the encoder for an output format
+func NewEncoder(format string) (Encoder, error) {
+	switch strings.ToLower(format) {
+	case "", FormatCSV:
+		return CSVEncoder{}, nil
+	case FormatParquet:
+		return ParquetEncoder{}, nil
 	}
+	return nil, fmt.Errorf("unknown result format %q (want %s or %s)", format, FormatCSV, FormatParquet)
+}
+
+// S3ResultSink writes each record type of a page as one object under
+// <format prefix><table>/basic_date=YYYYMMDD/hh=H/ (KST). The objects of one
+// page share a name.
+type S3ResultSink struct {
+	client s3iface.S3API
+	bucket string
+
+	// Encoder encodes the tables (default CSVEncoder)
+	Encoder Encoder
+
+	// Retry retries failed uploads on top of the SDK's own retries
+	Retry RetryPolicy
+}
 
-	// 데이터 작성
-	for _, item := range result {
-		// Device values are already optimized in scrapers (PC/Mobile)
-		record := []string{
-			item.Query,
-			item.Device,
-			strconv.Itoa(item.Rank),
-			item.SiteName,
-			item.DisplayURL,
-			item.Title,
-			item.Description,
+// uploadRetryPolicy retries an object upload a few times quickly; uploads
+// run in the short window persistTimeout leaves after the crawls
+var uploadRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    500 * time.Millisecond,
+}
+
+// NewS3ResultSink returns a ResultSink writing gzip CSV to bucket
+func NewS3ResultSink(client s3iface.S3API, bucket string) *S3ResultSink {
+	return &S3ResultSink{client: client, bucket: bucket, Encoder: CSVEncoder{}, Retry: uploadRetryPolicy}
+}
+
+// WritePage uploads the page's ads, organic results and sections, skipping
+// empty tables. Every table is encoded before any is uploaded, and when an
+// upload fails the tables already uploaded are deleted again, so a retry of
+// the page does not duplicate their rows.
+func (s *S3ResultSink) WritePage(ctx context.Context, hour time.Time, page PageResult) error {
+	encoder := s.Encoder
+	if encoder == nil {
+		encoder = CSVEncoder{}
+	}
+	name := fmt.Sprintf("%s/%s%s", partitionPath(hour), uuid.New().String()[:18], encoder.Extension())
+
+	tables := []struct {
+		prefix string
+		count  int
+		encode func() ([]byte, error)
+	}{
+		{resultPrefix, len(page.Ads), func() ([]byte, error) { return encoder.EncodeAds(page.Ads) }},
+		{organicPrefix, len(page.Organic), func() ([]byte, error) { return encoder.EncodeOrganic(page.Organic) }},
+		{sectionPrefix, len(page.Sections), func() ([]byte, error) { return encoder.EncodeSections(page.Sections) }},
+	}
+	encoded := make([][]byte, len(tables))
+	for i, table := range tables {
+		if table.count == 0 {
+			continue
 		}
-		if err := csvWriter.Write(record); err != nil {
-			log.Printf("❌ Failed to write CSV record: %v", err)
-			return
+		data, err := table.encode()
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", strings.TrimSuffix(table.prefix, "/"), err)
 		}
+		encoded[i] = data
 	}
 
-	csvWriter.Flush()
-	if err := csvWriter.Error(); err != nil {
-		log.Printf("❌ CSV writer error: %v", err)
-		return
-	}
-
-	if err := gzWriter.Close(); err != nil {
-		log.Printf("❌ Failed to close gzip writer: %v", err)
-		return
+	var uploaded []string
+	for i, table := range tables {
+		if encoded[i] == nil {
+			continue
+		}
+		key := encoder.TablePrefix() + table.prefix + name
+		if err := s.upload(ctx, key, encoder.ContentType(), encoded[i]); err != nil {
+			if len(uploaded) > 0 {
+				if deleteErr := deleteKeys(ctx, s.client, s.bucket, uploaded); deleteErr != nil {
+					log.Printf("😡 [ERROR] Failed to delete the partial upload %v, its rows will be duplicated by a retry: %v", uploaded, deleteErr)
+				}
+			}
+			return err
+		}
+		uploaded = append(uploaded, key)
+		log.Printf("✅ Successfully uploaded %d records to S3: %s", table.count, key)
 	}
+	return nil
+}
 
-	reader := bytes.NewReader(buffer.Bytes())
-	_, err = s3Client.PutObject(&s3.PutObjectInput{
-		Bucket:        aws.String(bucket),
-		Key:           aws.String(key),
-		Body:          reader,
-		ContentLength: aws.Int64(int64(reader.Len())),
-		ContentType:   aws.String("application/gzip"),
+// upload puts one encoded table, retrying throttling and transient errors
+func (s *S3ResultSink) upload(ctx context.Context, key, contentType string, data []byte) error {
+	err := s.Retry.retryIf(ctx, isRetryableUpload, func(attempt int) error {
+		_, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			Body:          bytes.NewReader(data),
+			ContentLength: aws.Int64(int64(len(data))),
+			ContentType:   aws.String(contentType),
+		})
+		if err != nil && attempt > 1 {
+			log.Printf("⚠️ Upload of %s failed again (attempt %d): %v", key, attempt, err)
+		}
+		return err
 	})
-
 	if err != nil {
-		log.Printf("❌ Failed to upload CSV.GZ to S3: %v", err)
-		return
+		return fmt.Errorf("failed to upload %s to S3: %w", key, err)
 	}
+	return nil
+}
 
-	log.Printf("✅ Successfully uploaded %d records to S3: %s", len(result), key)
+// isRetryableUpload reports whether a failed S3 request may succeed if sent
+// again: network errors, throttling (S3's SlowDown is a 503) and 5xx responses
+func isRetryableUpload(err error) bool {
+	var failure awserr.RequestFailure
+	if errors.As(err, &failure) && (failure.StatusCode() >= 500 || failure.StatusCode() == http.StatusTooManyRequests) {
+		return true
+	}
+	return request.IsErrorRetryable(err) || request.IsErrorThrottle(err)
 }
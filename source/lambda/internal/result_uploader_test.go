@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// flakyS3 fails the first failures uploads with err
+type flakyS3 struct {
+	*memS3
+	failures int
+	err      error
+	puts     int
+}
+
+func (f *flakyS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	f.puts++
+	if f.puts <= f.failures {
+		return nil, f.err
+	}
+	return f.memS3.PutObjectWithContext(ctx, in, opts...)
+}
+
+func TestS3ResultSinkRetriesUploads(t *testing.T) {
+	slowDown := awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), http.StatusServiceUnavailable, "req")
+	denied := awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "req")
+	page := PageResult{Ads: []SearchResult{{Query: "shoes", Rank: 1}}}
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		puts     int
+		wantErr  bool
+	}{
+		{"throttled then stored", 2, slowDown, 3, false},
+		{"throttled past the attempts", 3, slowDown, 3, true},
+		{"permanent failure", 1, denied, 1, true},
+	}
+	for _, tt := range tests {
+		client := &flakyS3{memS3: newMemS3(), failures: tt.failures, err: tt.err}
+		sink := NewS3ResultSink(client, "bucket")
+		sink.Retry = RetryPolicy{MaxAttempts: 3}
+
+		err := sink.WritePage(context.Background(), hour, page)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want it to wrap %v", tt.name, err, tt.err)
+		}
+		if client.puts != tt.puts {
+			t.Errorf("%s: got %d uploads, want %d", tt.name, client.puts, tt.puts)
+		}
+		if stored := len(client.keys(resultPrefix)); (stored == 1) == tt.wantErr {
+			t.Errorf("%s: got %d stored objects", tt.name, stored)
+		}
+	}
+}
+
+// prefixFailingS3 fails every upload of a key under prefix
+type prefixFailingS3 struct {
+	*memS3
+	prefix string
+}
+
+func (f *prefixFailingS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if strings.HasPrefix(aws.StringValue(in.Key), f.prefix) {
+		return nil, awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "req")
+	}
+	return f.memS3.PutObjectWithContext(ctx, in, opts...)
+}
+
+func TestS3ResultSinkRemovesPartialPages(t *testing.T) {
+	page := PageResult{
+		Ads:      []SearchResult{{Query: "shoes", Rank: 1}},
+		Organic:  []OrganicResult{{Query: "shoes", Rank: 1}},
+		Sections: []SerpSection{{Query: "shoes", Position: 1}},
+	}
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+
+	// The ads are uploaded before the organic results fail
+	client := &prefixFailingS3{memS3: newMemS3(), prefix: organicPrefix}
+	if err := NewS3ResultSink(client, "bucket").WritePage(context.Background(), hour, page); err == nil {
+		t.Fatal("got no error with a failed table")
+	}
+	if keys := client.keys(""); len(keys) != 0 {
+		t.Errorf("got objects %v left, want none of the page", keys)
+	}
+
+	client.prefix = "none/"
+	if err := NewS3ResultSink(client, "bucket").WritePage(context.Background(), hour, page); err != nil {
+		t.Fatal(err)
+	}
+	if keys := client.keys(""); len(keys) != 3 {
+		t.Errorf("got objects %v, want one per table", keys)
+	}
+}
+
+func TestS3ResultSinkKeepsFormatsApart(t *testing.T) {
+	page := PageResult{Ads: []SearchResult{{Query: "shoes", Rank: 1}}, Organic: []OrganicResult{{Query: "shoes", Rank: 1}}}
+	hour := time.Date(2025, 8, 13, 9, 0, 0, 0, kst)
+	client := newMemS3()
+	for _, encoder := range []Encoder{CSVEncoder{}, ParquetEncoder{}} {
+		sink := NewS3ResultSink(client, "bucket")
+		sink.Encoder = encoder
+		if err := sink.WritePage(context.Background(), hour, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each table prefix holds a single format
+	for prefix, extension := range map[string]string{
+		resultPrefix:               ".csv.gz",
+		organicPrefix:              ".csv.gz",
+		"parquet/" + resultPrefix:  ".parquet",
+		"parquet/" + organicPrefix: ".parquet",
+	} {
+		keys := client.keys(prefix)
+		if len(keys) != 1 || !strings.HasSuffix(keys[0], extension) {
+			t.Errorf("got %v under %s, want one %s object", keys, prefix, extension)
+		}
+	}
+}
+
+func TestFailedUploadReleasesMessage(t *testing.T) {
+	queue := NewMemoryQueue(`{"keyword":"shoes"}`, `{"keyword":"boots"}`)
+	sink := &MemorySink{Err: errors.New("s3 down")}
+	processor := NewProcessor(Config{}, Dependencies{Queue: queue, Sink: sink})
+
+	ctx := context.Background()
+	messages, err := processor.ReceiveMessages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor.results.Add(messages[0], DeviceDesktop, PageResult{Ads: []SearchResult{{Query: "shoes"}}})
+
+	// The message without results can go; the one whose upload failed is
+	// visible again right away rather than after its visibility timeout
+	if durable := processor.CommitMessages(ctx, messages); len(durable) != 1 || durable[0].ID != messages[1].ID {
+		t.Errorf("got %v committed, want only the message without results", durable)
+	}
+	if durable := processor.FlushResults(ctx); len(durable) != 0 {
+		t.Errorf("got %v flushed, want none", durable)
+	}
+	if visible := queue.Visible(); len(visible) != 1 || visible[0] != messages[0].Body {
+		t.Errorf("got visible %v, want %s", visible, messages[0].Body)
+	}
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBlocked is wrapped by errors for requests Naver refused (403 or a captcha page)
+var ErrBlocked = errors.New("request blocked by Naver")
+
+// ErrAttemptBudgetExhausted is returned when a keyword used up its attempt budget
+var ErrAttemptBudgetExhausted = errors.New("keyword retry budget exhausted")
+
+// ScrapeError is a failed request to Naver, classified by whether trying again may help
+type ScrapeError struct {
+	// StatusCode is the HTTP status, or 0 when no response was received
+	StatusCode int
+
+	// Retryable is true for timeouts, network errors, 429 and 5xx responses
+	Retryable bool
+
+	// RetryAfter is the delay requested by the server's Retry-After header
+	RetryAfter time.Duration
+
+	Err error
+}
+
+func (e *ScrapeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is a transient failure worth trying again.
+// Unclassified errors, including a bare cancelled context, are not retryable;
+// a client timeout is, since networkError only marks failures of a live context.
+func IsRetryable(err error) bool {
+	var scrapeErr *ScrapeError
+	return errors.As(err, &scrapeErr) && scrapeErr.Retryable
+}
+
+// networkError wraps a failed round trip. Any network failure is worth retrying
+// unless ctx itself is done; a client timeout also wraps context.DeadlineExceeded,
+// so the error alone cannot tell the two apart.
+func networkError(ctx context.Context, err error) error {
+	return &ScrapeError{Retryable: ctx.Err() == nil, Err: fmt.Errorf("network request failed: %w", err)}
+}
+
+// statusError classifies a non-200 response
+func statusError(resp *http.Response) error {
+	code := resp.StatusCode
+	err := fmt.Errorf("unexpected status code: %d", code)
+	if code == http.StatusForbidden {
+		err = fmt.Errorf("%w: %w", ErrBlocked, err)
+	}
+	return &ScrapeError{
+		StatusCode: code,
+		Retryable:  code == http.StatusTooManyRequests || code >= 500,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Err:        err,
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// RetryPolicy controls how a single page request is retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries per page request, including the first
+	MaxAttempts int
+
+	// BaseDelay is the backoff before the first retry; it doubles every retry
+	BaseDelay time.Duration
+
+	// MaxDelay caps the backoff. A Retry-After longer than this is not waited for.
+	MaxDelay time.Duration
+
+	// KeywordBudget is the total number of retries one keyword may make
+	// across all its devices and pages, so a struggling keyword cannot hog a
+	// worker. First attempts are not counted, so pagination does not use it up.
+	KeywordBudget int
+}
+
+// DefaultRetryPolicy is used when no policy is configured
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   3,
+	BaseDelay:     200 * time.Millisecond,
+	MaxDelay:      2 * time.Second,
+	KeywordBudget: 5,
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy sets the retry policy scrapers use for requests made with ctx
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// retryPolicyFrom returns the policy attached to ctx, or DefaultRetryPolicy
+func retryPolicyFrom(ctx context.Context) RetryPolicy {
+	if policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return policy
+	}
+	return DefaultRetryPolicy
+}
+
+// backoff returns the delay before retry number attempt (1-based) using
+// exponential backoff with full jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if ceiling > float64(p.MaxDelay) {
+		ceiling = float64(p.MaxDelay)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// retry calls fn until it succeeds, fails permanently, runs out of attempts
+// or the keyword's retry budget, or ctx is done
+func (p RetryPolicy) retry(ctx context.Context, fn func(attempt int) error) error {
+	return p.retryIf(ctx, IsRetryable, fn)
+}
+
+// retryIf is retry with retryable deciding which errors are transient
+func (p RetryPolicy) retryIf(ctx context.Context, retryable func(error) bool, fn func(attempt int) error) error {
+	budget := attemptBudgetFrom(ctx)
+	maxAttempts := max(p.MaxAttempts, 1)
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 && !budget.take() {
+			return fmt.Errorf("%w: %w", ErrAttemptBudgetExhausted, err)
+		}
+
+		if err = fn(attempt); err == nil || !retryable(err) || attempt == maxAttempts {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		var scrapeErr *ScrapeError
+		if errors.As(err, &scrapeErr) && scrapeErr.RetryAfter > delay {
+			if scrapeErr.RetryAfter > p.MaxDelay {
+				return err
+			}
+			delay = scrapeErr.RetryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+// AttemptBudget is the number of retries a keyword may still make. It is
+// shared by every device and page crawled for the same message.
+type AttemptBudget struct {
+	remaining atomic.Int64
+}
+
+// NewAttemptBudget returns a budget allowing n retries
+func NewAttemptBudget(n int) *AttemptBudget {
+	b := &AttemptBudget{}
+	b.remaining.Store(int64(n))
+	return b
+}
+
+// take consumes one retry, reporting false when none are left.
+// A nil budget is unlimited.
+func (b *AttemptBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	return b.remaining.Add(-1) >= 0
+}
+
+type attemptBudgetKey struct{}
+
+// WithAttemptBudget attaches a keyword attempt budget to ctx
+func WithAttemptBudget(ctx context.Context, budget *AttemptBudget) context.Context {
+	return context.WithValue(ctx, attemptBudgetKey{}, budget)
+}
+
+func attemptBudgetFrom(ctx context.Context) *AttemptBudget {
+	budget, _ := ctx.Value(attemptBudgetKey{}).(*AttemptBudget)
+	return budget
+}
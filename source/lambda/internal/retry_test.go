@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// transient returns a retryable failure, with the given Retry-After
+func transient(retryAfter time.Duration) error {
+	return &ScrapeError{StatusCode: http.StatusServiceUnavailable, Retryable: true, RetryAfter: retryAfter, Err: errors.New("503")}
+}
+
+func TestBackoffStaysWithinCeiling(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	ceilings := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+	for i, ceiling := range ceilings {
+		for range 200 {
+			if delay := policy.backoff(i + 1); delay <= 0 || delay > ceiling {
+				t.Fatalf("retry %d: got backoff %v, want within (0, %v]", i+1, delay, ceiling)
+			}
+		}
+	}
+	if delay := (RetryPolicy{}).backoff(1); delay != 0 {
+		t.Errorf("got backoff %v without a base delay, want 0", delay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 8, 13, 9, 0, 0, 0, time.UTC)
+	for value, want := range map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		" 3 ":                           3 * time.Second,
+		"0":                             0,
+		"-1":                            0,
+		"soon":                          0,
+		"Wed, 13 Aug 2025 09:00:05 GMT": 5 * time.Second,
+		"Wed, 13 Aug 2025 08:59:00 GMT": 0,
+	} {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("Retry-After %q: got %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestStatusErrorClassification(t *testing.T) {
+	for _, tc := range []struct {
+		status    int
+		retryable bool
+		blocked   bool
+	}{
+		{http.StatusTooManyRequests, true, false},
+		{http.StatusInternalServerError, true, false},
+		{http.StatusServiceUnavailable, true, false},
+		{http.StatusForbidden, false, true},
+		{http.StatusNotFound, false, false},
+		{http.StatusBadRequest, false, false},
+	} {
+		resp := &http.Response{StatusCode: tc.status, Header: http.Header{"Retry-After": {"2"}}}
+		err := statusError(resp)
+		if IsRetryable(err) != tc.retryable || errors.Is(err, ErrBlocked) != tc.blocked {
+			t.Errorf("status %d: got retryable %v, blocked %v; want %v, %v",
+				tc.status, IsRetryable(err), errors.Is(err, ErrBlocked), tc.retryable, tc.blocked)
+		}
+		var scrapeErr *ScrapeError
+		if !errors.As(err, &scrapeErr) || scrapeErr.StatusCode != tc.status || scrapeErr.RetryAfter != 2*time.Second {
+			t.Errorf("status %d: got %+v, want the status and a 2s Retry-After", tc.status, scrapeErr)
+		}
+	}
+
+	// A network failure is transient unless the crawl itself was cancelled
+	if err := networkError(context.Background(), context.DeadlineExceeded); !IsRetryable(err) {
+		t.Errorf("client timeout %v not retryable", err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := networkError(cancelled, context.Canceled); IsRetryable(err) {
+		t.Errorf("cancelled request %v retryable", err)
+	}
+	if IsRetryable(errors.New("unclassified")) {
+		t.Error("unclassified error retryable")
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	ctx := context.Background()
+	permanent := &ScrapeError{StatusCode: http.StatusNotFound, Err: errors.New("404")}
+
+	for _, tc := range []struct {
+		name      string
+		fails     []error
+		calls     int
+		failed    bool
+		retryable bool
+	}{
+		{"success", nil, 1, false, false},
+		{"recovers", []error{transient(0)}, 2, false, false},
+		{"permanent", []error{permanent}, 1, true, false},
+		{"keeps failing", []error{transient(0), transient(0), transient(0), transient(0)}, 3, true, true},
+	} {
+		calls := 0
+		err := policy.retry(ctx, func(attempt int) error {
+			if calls++; attempt != calls {
+				t.Errorf("%s: got attempt %d on call %d", tc.name, attempt, calls)
+			}
+			if attempt <= len(tc.fails) {
+				return tc.fails[attempt-1]
+			}
+			return nil
+		})
+		if calls != tc.calls {
+			t.Errorf("%s: got %d calls, want %d", tc.name, calls, tc.calls)
+		}
+		if (err != nil) != tc.failed || IsRetryable(err) != tc.retryable {
+			t.Errorf("%s: got %v, want failed %v and retryable %v", tc.name, err, tc.failed, tc.retryable)
+		}
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 100 * time.Millisecond}
+	ctx := context.Background()
+
+	// A Retry-After within MaxDelay is waited for instead of the shorter backoff
+	start := time.Now()
+	calls := 0
+	err := policy.retry(ctx, func(int) error {
+		if calls++; calls == 1 {
+			return transient(30 * time.Millisecond)
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("got %v after %d calls, want success on the retry", err, calls)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("retried after %v, want at least the 30ms Retry-After", elapsed)
+	}
+
+	// A longer one is not waited for: the request fails at once
+	calls = 0
+	err = policy.retry(ctx, func(int) error {
+		calls++
+		return transient(time.Second)
+	})
+	if calls != 1 || !IsRetryable(err) {
+		t.Errorf("got %v after %d calls, want the failure without a retry", err, calls)
+	}
+}
+
+func TestRetryStopsWithBudgetOrContext(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	// Two requests share a budget of one retry
+	ctx := WithAttemptBudget(context.Background(), NewAttemptBudget(1))
+	calls := 0
+	failing := func(int) error {
+		calls++
+		return transient(0)
+	}
+	if err := policy.retry(ctx, failing); !errors.Is(err, ErrAttemptBudgetExhausted) || !IsRetryable(err) || calls != 2 {
+		t.Errorf("got %v after %d calls, want the budget exhausted with its cause after 2", err, calls)
+	}
+	calls = 0
+	if err := policy.retry(ctx, failing); !errors.Is(err, ErrAttemptBudgetExhausted) || calls != 1 {
+		t.Errorf("got %v after %d calls, want the budget exhausted after the first attempt", err, calls)
+	}
+
+	// A done context ends the backoff
+	cancelled, cancel := context.WithCancel(context.Background())
+	slow := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	err := slow.retry(cancelled, func(int) error {
+		cancel()
+		return transient(0)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
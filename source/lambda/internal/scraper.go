@@ -0,0 +1,439 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/google/uuid"
+)
+
+// Scraper fetches a Naver result page for a keyword and extracts its ads,
+// organic results and sections
+type Scraper interface {
+	// Device returns the device code written to SearchResult.Device
+	Device() string
+
+	// Scrape crawls the keyword, aborting the request when ctx is done
+	Scrape(ctx context.Context, keyword string) (PageResult, error)
+}
+
+// SelectorSet holds the CSS selectors used to extract ads from a result page.
+// Result matches one ad item; the other selectors are relative to that item.
+type SelectorSet struct {
+	Result string `json:"result"`
+	Site   string `json:"site"`
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Desc   string `json:"desc"`
+
+	// Link matches the anchor whose href is the landing URL
+	Link string `json:"link,omitempty"`
+
+	// Extensions are optional; an empty selector skips that extension
+	Extensions ExtensionSelectors `json:"extensions,omitempty"`
+
+	// Blocks name the ad block an item belongs to, checked in order against
+	// the item's ancestors; items outside every block are AdBlockPowerLinkTop
+	Blocks []BlockSelector `json:"blocks,omitempty"`
+
+	// Sections locate the page sections and organic results
+	Sections SerpSelectors `json:"sections,omitempty"`
+
+	// Pagination leads to the ad list pages beyond the result page
+	Pagination PaginationSelectors `json:"pagination,omitempty"`
+
+	// Layout is used to detect selector drift when no result matches
+	Layout LayoutMarkers `json:"layout"`
+}
+
+// ExtensionSelectors match ad extensions, relative to the ad item
+type ExtensionSelectors struct {
+	Sitelink  string `json:"sitelink,omitempty"`
+	Phone     string `json:"phone,omitempty"`
+	Price     string `json:"price,omitempty"`
+	Promotion string `json:"promotion,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// BlockSelector maps an ad block container to its name
+type BlockSelector struct {
+	Name      string `json:"name"`
+	Container string `json:"container"`
+}
+
+// DeviceProfile declares everything that differs between search surfaces.
+// Adding a surface (tablet, another Naver vertical, ...) only needs a new
+// profile passed to RegisterProfile; fetching and parsing are shared.
+type DeviceProfile struct {
+	// Device is the code written to SearchResult.Device and used in SearchRequest.Device
+	Device string
+
+	// SearchURL is the search URL template with a single %s for the escaped keyword
+	SearchURL string
+
+	// Selectors are used only when the selector config has no entry for Device
+	Selectors SelectorSet
+
+	// Client is the HTTP client used for this surface's requests
+	Client *http.Client
+}
+
+// profileScraper is the Scraper implementation driven by a DeviceProfile
+type profileScraper struct {
+	profile DeviceProfile
+}
+
+// NewScraper returns a Scraper for the given profile
+func NewScraper(profile DeviceProfile) Scraper {
+	return &profileScraper{profile: profile}
+}
+
+func (s *profileScraper) Device() string {
+	return s.profile.Device
+}
+
+func (s *profileScraper) Scrape(ctx context.Context, keyword string) (PageResult, error) {
+	return s.scrape(ctx, keyword, false)
+}
+
+// scrape performs the actual fetch and parse, printing progress when debug is set.
+// Transient failures are retried according to the current RetryPolicy.
+func (s *profileScraper) scrape(ctx context.Context, keyword string, debug bool) (PageResult, error) {
+	selectors, err := selectorsFor(s.profile)
+	if err != nil {
+		return PageResult{}, err
+	}
+
+	// Build request URL
+	encodedKeyword := url.QueryEscape(keyword)
+	targetURL := fmt.Sprintf(s.profile.SearchURL, encodedKeyword)
+
+	// Every page of the crawl shares a request ID
+	crawl := CrawlMeta{RequestID: uuid.NewString(), LambdaRequestID: lambdaRequestID(ctx)}
+
+	doc, meta, err := s.fetchPage(ctx, keyword, targetURL, 1, crawl, debug)
+	if err != nil {
+		return PageResult{}, err
+	}
+
+	// Extract search results
+	page, err := extractPage(doc, keyword, s.profile.Device, selectors)
+	if err != nil {
+		return PageResult{}, err
+	}
+	page.stamp(meta)
+	page.CrawledAt = meta.CrawledAt
+
+	if depth := adPageDepthFrom(ctx); depth > 1 {
+		page.Ads, err = s.paginate(ctx, keyword, doc, targetURL, page.Ads, depth, selectors, meta, debug)
+		if err != nil {
+			return PageResult{}, err
+		}
+	}
+	return page, nil
+}
+
+// paginate follows the ad list pages after the result page doc, up to depth
+// pages in total, and returns the ads of all pages. first is the metadata of
+// the result page. Pagination is best effort: a page that fails is logged
+// and the ads found so far are kept. Only a done ctx is returned as an error.
+func (s *profileScraper) paginate(ctx context.Context, keyword string, doc *goquery.Document, pageURL string, ads []SearchResult, depth int, sel SelectorSet, first CrawlMeta, debug bool) ([]SearchResult, error) {
+	var pager adPager
+	pager.add(ads, 1)
+
+	listSel := sel.adListSelectors()
+	next := nextPageURL(doc, pageURL, sel.Pagination.More)
+	for page := 2; page <= depth && next != ""; page++ {
+		doc, meta, err := s.fetchPage(ctx, keyword, next, page, first, debug)
+		if err == nil {
+			ads, err = extractResults(doc, keyword, s.profile.Device, listSel)
+		}
+		if isContextDone(err) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("⚠️ Ad list page %d of %s (%s) failed, keeping %d ads: %v", page, keyword, s.profile.Device, len(pager.ads), err)
+			recordScrapeError(s.profile.Device, err)
+			break
+		}
+		stampAds(ads, meta)
+		if pager.add(ads, page) == 0 {
+			break
+		}
+		next = nextPageURL(doc, next, sel.Pagination.Next)
+	}
+	return pager.ads, nil
+}
+
+// fetchPage fetches and archives one page of a crawl, retrying transient
+// failures according to the current RetryPolicy. The returned metadata is
+// crawl's with the fetch details of the attempt that succeeded. For ad list
+// pages crawl is the result page's metadata, whose CrawledAt decides the
+// archive partition of the whole crawl.
+func (s *profileScraper) fetchPage(ctx context.Context, keyword, targetURL string, page int, crawl CrawlMeta, debug bool) (*goquery.Document, CrawlMeta, error) {
+	if debug {
+		fmt.Printf("Target URL: %s\n", targetURL)
+	}
+
+	var doc *goquery.Document
+	var body []byte
+	meta := crawl
+	err := retryPolicyFrom(ctx).retry(ctx, func(attempt int) error {
+		if debug && attempt > 1 {
+			fmt.Printf("Retrying (attempt %d)\n", attempt)
+		}
+		start := time.Now()
+		var err error
+		doc, body, err = s.fetch(ctx, targetURL, &meta, debug)
+		observeRequest(ctx, start, time.Duration(meta.LatencyMs)*time.Millisecond, err)
+		return err
+	})
+	if err != nil {
+		return nil, CrawlMeta{}, err
+	}
+
+	// Archive before extracting so captcha and drifted pages are kept too
+	archivePage(ctx, RawPage{Keyword: keyword, Device: s.profile.Device, Page: page, CrawlMeta: meta, Partition: crawl.CrawledAt, Body: body})
+	return doc, meta, nil
+}
+
+// fetch requests a single page and parses it, classifying failures as *ScrapeError.
+// The raw body is returned alongside the document for archival, and the
+// request's user agent, status, latency and receive time are set in meta.
+func (s *profileScraper) fetch(ctx context.Context, targetURL string, meta *CrawlMeta, debug bool) (*goquery.Document, []byte, error) {
+	// Create HTTP request with random headers
+	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add randomized headers to avoid detection
+	headers := GenerateRandomHeaders()
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	// Execute request
+	client := s.profile.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// Waiting for the limiter counts neither against the client timeout nor
+	// towards the latency
+	if err := outboundLimiter.Wait(ctx, req.URL.Host); err != nil {
+		return nil, nil, networkError(ctx, err)
+	}
+	meta.UserAgent = req.Header.Get("User-Agent")
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, networkError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if debug {
+		fmt.Printf("Response Status: %d\n", resp.StatusCode)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, nil, statusError(resp)
+	}
+
+	// A failure here is almost always a body cut off mid-read
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, &ScrapeError{StatusCode: resp.StatusCode, Retryable: true, Err: fmt.Errorf("failed to read body: %w", err)}
+	}
+	meta.CrawledAt = time.Now().UTC()
+	meta.HTTPStatus = resp.StatusCode
+	meta.LatencyMs = time.Since(start).Milliseconds()
+
+	// Parse HTML document
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, &ScrapeError{StatusCode: resp.StatusCode, Retryable: true, Err: fmt.Errorf("failed to parse HTML: %w", err)}
+	}
+	return doc, body, nil
+}
+
+// extractResults parses the HTML document and extracts search results using the given selectors
+func extractResults(doc *goquery.Document, keyword, device string, sel SelectorSet) ([]SearchResult, error) {
+	if err := detectBlocked(doc, sel.Layout); err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	ranks := adRanks{}
+
+	// Find all search result items
+	doc.Find(sel.Result).Each(func(_ int, s *goquery.Selection) {
+		// Extract site name
+		siteName := strings.TrimSpace(s.Find(sel.Site).Text())
+
+		// Extract display URL
+		displayURL := strings.TrimSpace(s.Find(sel.URL).Text())
+		displayURL = sanitizeURL(displayURL)
+
+		// Extract title (may have multiple parts)
+		var titleParts []string
+		s.Find(sel.Title).Each(func(j int, titleElement *goquery.Selection) {
+			titleText := strings.TrimSpace(titleElement.Text())
+			if titleText != "" {
+				titleParts = append(titleParts, titleText)
+			}
+		})
+		title := strings.Join(titleParts, " · ")
+
+		// Extract description
+		description := strings.TrimSpace(s.Find(sel.Desc).Text())
+
+		// Set default values for empty fields
+		siteName = setDefaultValueIfEmpty(siteName, DefaultSiteName)
+		displayURL = setDefaultValueIfEmpty(displayURL, DefaultURL)
+		title = setDefaultValueIfEmpty(title, DefaultTitle)
+		description = setDefaultValueIfEmpty(description, DefaultDescription)
+
+		// Create search result
+		block := adBlock(s, sel.Blocks)
+		result := SearchResult{
+			Query:       keyword,
+			Device:      device,
+			Rank:        ranks.next(block),
+			Page:        1,
+			SiteName:    siteName,
+			DisplayURL:  displayURL,
+			Title:       title,
+			Description: description,
+			AdBlock:     block,
+			Extensions:  extractExtensions(s, sel.Extensions),
+		}
+		if sel.Link != "" {
+			href, _ := s.Find(sel.Link).First().Attr("href")
+			result.LandingURL = landingURL(href)
+		}
+
+		results = append(results, result)
+	})
+
+	if len(results) == 0 {
+		if err := detectLayoutChange(doc, keyword, device, sel.Layout); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Registered scrapers, keyed by device code
+var (
+	scrapersMu sync.RWMutex
+	scrapers   = map[string]*profileScraper{}
+)
+
+// RegisterProfile makes a profile available to message processing under its device code.
+// Registering the same device twice replaces the earlier profile.
+func RegisterProfile(profile DeviceProfile) {
+	scrapersMu.Lock()
+	defer scrapersMu.Unlock()
+	scrapers[strings.ToUpper(profile.Device)] = &profileScraper{profile: profile}
+}
+
+// ScraperFor returns the scraper registered for a device code
+func ScraperFor(device string) (Scraper, bool) {
+	scrapersMu.RLock()
+	defer scrapersMu.RUnlock()
+	s, ok := scrapers[strings.ToUpper(device)]
+	if !ok {
+		return nil, false
+	}
+	return s, true
+}
+
+// SetSearchBaseURL points the profile registered for device at another host,
+// keeping the path and query of its SearchURL. It is used to run against a
+// local stand-in such as FakeNaver.
+func SetSearchBaseURL(device, base string) error {
+	scrapersMu.Lock()
+	defer scrapersMu.Unlock()
+	s, ok := scrapers[strings.ToUpper(device)]
+	if !ok {
+		return fmt.Errorf("no profile registered for device %q", device)
+	}
+	searchURL, err := withBaseURL(s.profile.SearchURL, base)
+	if err != nil {
+		return err
+	}
+	profile := s.profile
+	profile.SearchURL = searchURL
+	scrapers[strings.ToUpper(device)] = &profileScraper{profile: profile}
+	return nil
+}
+
+// withBaseURL replaces the scheme and host of a SearchURL template with base.
+// The template is not parsed as a URL since its %s placeholder is not a valid escape.
+func withBaseURL(template, base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("search base URL %q is not an absolute URL", base)
+	}
+	_, rest, ok := strings.Cut(template, "://")
+	if !ok {
+		return "", fmt.Errorf("search URL %q has no scheme", template)
+	}
+	path := ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		path = rest[i:]
+	}
+	return strings.TrimRight(base, "/") + path, nil
+}
+
+// registeredScraper returns the scraper registered for profile's device so
+// that base URL overrides apply, or a scraper for profile itself
+func registeredScraper(profile DeviceProfile) *profileScraper {
+	scrapersMu.RLock()
+	defer scrapersMu.RUnlock()
+	if s, ok := scrapers[strings.ToUpper(profile.Device)]; ok {
+		return s
+	}
+	return &profileScraper{profile: profile}
+}
+
+// profileFor returns the profile registered for a device code
+func profileFor(device string) (DeviceProfile, bool) {
+	scrapersMu.RLock()
+	defer scrapersMu.RUnlock()
+	s, ok := scrapers[strings.ToUpper(device)]
+	if !ok {
+		return DeviceProfile{}, false
+	}
+	return s.profile, true
+}
+
+// registeredProfiles returns the profiles of all registered scrapers
+func registeredProfiles() []DeviceProfile {
+	scrapersMu.RLock()
+	defer scrapersMu.RUnlock()
+	profiles := make([]DeviceProfile, 0, len(scrapers))
+	for _, s := range scrapers {
+		profiles = append(profiles, s.profile)
+	}
+	return profiles
+}
+
+// lambdaRequestID returns the AWS request ID of the invocation ctx belongs to, if any
+func lambdaRequestID(ctx context.Context) string {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		return lc.AwsRequestID
+	}
+	return ""
+}
@@ -0,0 +1,321 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// SelectorSource says where the selector config is loaded from. The S3 object
+// takes precedence over the local file; without either the embedded
+// selectors.json is used.
+type SelectorSource struct {
+	File  string
+	S3URI string
+
+	// RefreshInterval is how often warm containers re-check the source
+	RefreshInterval time.Duration
+}
+
+//go:embed selectors.json
+var embeddedSelectorConfig []byte
+
+// SelectorConfig is the versioned selector file, keyed by device code
+type SelectorConfig struct {
+	Version string                 `json:"version"`
+	Devices map[string]SelectorSet `json:"devices"`
+}
+
+// ParseSelectorConfig decodes and validates a selector config file
+func ParseSelectorConfig(data []byte) (*SelectorConfig, error) {
+	var config SelectorConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to decode selector config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Validate checks that every device has all selectors and that each one compiles
+func (c *SelectorConfig) Validate() error {
+	if strings.TrimSpace(c.Version) == "" {
+		return errors.New("selector config: version is required")
+	}
+	if len(c.Devices) == 0 {
+		return errors.New("selector config: no devices defined")
+	}
+	for device, set := range c.Devices {
+		if err := set.Validate(); err != nil {
+			return fmt.Errorf("selector config %s: device %s: %w", c.Version, device, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that every selector in the set is present and compiles
+func (s SelectorSet) Validate() error {
+	fields := []struct{ name, value string }{
+		{"result", s.Result},
+		{"site", s.Site},
+		{"url", s.URL},
+		{"title", s.Title},
+		{"desc", s.Desc},
+	}
+	for _, field := range fields {
+		if strings.TrimSpace(field.value) == "" {
+			return fmt.Errorf("%s selector is empty", field.name)
+		}
+		if _, err := cascadia.Compile(field.value); err != nil {
+			return fmt.Errorf("%s selector %q is invalid: %w", field.name, field.value, err)
+		}
+	}
+
+	// The remaining selectors are optional
+	optional := []struct{ name, value string }{
+		{"link", s.Link},
+		{"sitelink", s.Extensions.Sitelink},
+		{"phone", s.Extensions.Phone},
+		{"price", s.Extensions.Price},
+		{"promotion", s.Extensions.Promotion},
+		{"thumbnail", s.Extensions.Thumbnail},
+	}
+	for _, block := range s.Blocks {
+		if block.Name == "" || block.Container == "" {
+			return fmt.Errorf("block %q needs both a name and a container", block.Name)
+		}
+		optional = append(optional, struct{ name, value string }{"block " + block.Name, block.Container})
+	}
+	if err := compileOptional(optional); err != nil {
+		return err
+	}
+	if err := s.Sections.validate(); err != nil {
+		return err
+	}
+	if err := s.Pagination.validate(); err != nil {
+		return err
+	}
+	return s.Layout.Validate()
+}
+
+// compileOptional checks that every non-empty selector compiles
+func compileOptional(fields []struct{ name, value string }) error {
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		if _, err := cascadia.Compile(field.value); err != nil {
+			return fmt.Errorf("%s selector %q is invalid: %w", field.name, field.value, err)
+		}
+	}
+	return nil
+}
+
+// selectorStore holds the active selector config shared by all scrapers
+type selectorStore struct {
+	mu       sync.RWMutex
+	config   *SelectorConfig
+	source   string
+	etag     string
+	loadedAt time.Time
+}
+
+var activeSelectors = &selectorStore{}
+
+// current returns the active config, falling back to the embedded one
+// when LoadSelectorConfig has not been called (e.g. in debug tools)
+func (s *selectorStore) current() *SelectorConfig {
+	s.mu.RLock()
+	config := s.config
+	s.mu.RUnlock()
+	if config != nil {
+		return config
+	}
+
+	config, err := ParseSelectorConfig(embeddedSelectorConfig)
+	if err != nil {
+		panic(fmt.Sprintf("embedded selectors.json is invalid: %v", err))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config == nil {
+		s.config, s.source, s.loadedAt = config, "embedded", time.Now()
+	}
+	return s.config
+}
+
+// selectorsFor resolves the selectors for a profile. The loaded config wins so
+// markup changes can be shipped without a redeploy; the profile's own selectors
+// are the fallback for surfaces the config does not know about.
+func selectorsFor(profile DeviceProfile) (SelectorSet, error) {
+	return resolveSelectors(activeSelectors.current(), profile)
+}
+
+// resolveSelectors is selectorsFor with config as the loaded config
+func resolveSelectors(config *SelectorConfig, profile DeviceProfile) (SelectorSet, error) {
+	if set, ok := config.Devices[strings.ToUpper(profile.Device)]; ok {
+		return set, nil
+	}
+	if profile.Selectors.Result != "" {
+		return profile.Selectors, nil
+	}
+	return SelectorSet{}, fmt.Errorf("no selectors configured for device %s", profile.Device)
+}
+
+// checkProfiles makes sure every registered device profile resolves to a
+// selector set under config
+func checkProfiles(config *SelectorConfig) error {
+	for _, profile := range registeredProfiles() {
+		if _, err := resolveSelectors(config, profile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSelectorConfig loads and validates the selector config at startup.
+// Every registered device profile must resolve to a selector set.
+func (p *Processor) LoadSelectorConfig(ctx context.Context) error {
+	config, source, etag, err := fetchSelectorConfig(ctx, p.cfg.Selectors, p.deps.S3, "")
+	if err != nil {
+		return err
+	}
+	if err := checkProfiles(config); err != nil {
+		return err
+	}
+
+	activeSelectors.mu.Lock()
+	activeSelectors.config, activeSelectors.source, activeSelectors.etag = config, source, etag
+	activeSelectors.loadedAt = time.Now()
+	activeSelectors.mu.Unlock()
+
+	log.Printf("🔧 Selector config %s loaded from %s", config.Version, source)
+	return nil
+}
+
+// RefreshSelectorConfig reloads the selector config once the refresh interval
+// has elapsed, so warm containers pick up changes without a redeploy. A config
+// that fails to load or validate is logged and the current one is kept.
+func (p *Processor) RefreshSelectorConfig(ctx context.Context) {
+	activeSelectors.mu.RLock()
+	due := activeSelectors.config == nil || time.Since(activeSelectors.loadedAt) >= p.cfg.Selectors.RefreshInterval
+	previous, etag := activeSelectors.config, activeSelectors.etag
+	activeSelectors.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	config, source, newETag, err := fetchSelectorConfig(ctx, p.cfg.Selectors, p.deps.S3, etag)
+	if err == nil && config != nil {
+		err = checkProfiles(config)
+	}
+
+	activeSelectors.mu.Lock()
+	defer activeSelectors.mu.Unlock()
+	activeSelectors.loadedAt = time.Now()
+
+	if err != nil {
+		log.Printf("⚠️ Failed to refresh selector config, keeping current one: %v", err)
+		return
+	}
+	if config == nil {
+		return // not modified
+	}
+
+	activeSelectors.config, activeSelectors.source, activeSelectors.etag = config, source, newETag
+	if previous == nil || previous.Version != config.Version {
+		log.Printf("🔧 Selector config %s loaded from %s", config.Version, source)
+	}
+}
+
+// fetchSelectorConfig reads the config from origin. It returns a nil config
+// without error when the S3 object still matches etag.
+func fetchSelectorConfig(ctx context.Context, origin SelectorSource, client s3iface.S3API, etag string) (*SelectorConfig, string, string, error) {
+	var (
+		data    []byte
+		source  string
+		newETag string
+	)
+
+	if origin.S3URI != "" {
+		body, tag, notModified, err := readSelectorObject(ctx, client, origin.S3URI, etag)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if notModified {
+			return nil, origin.S3URI, etag, nil
+		}
+		data, source, newETag = body, origin.S3URI, tag
+	} else if origin.File != "" {
+		body, err := os.ReadFile(origin.File)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("failed to read selector config: %w", err)
+		}
+		data, source = body, origin.File
+	} else {
+		data, source = embeddedSelectorConfig, "embedded"
+	}
+
+	config, err := ParseSelectorConfig(data)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("%s: %w", source, err)
+	}
+	return config, source, newETag, nil
+}
+
+// readSelectorObject downloads an s3://bucket/key object unless it still matches etag
+func readSelectorObject(ctx context.Context, client s3iface.S3API, uri, etag string) ([]byte, string, bool, error) {
+	if client == nil {
+		return nil, "", false, fmt.Errorf("selector config URI %q set but no S3 client configured", uri)
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "s3" || parsed.Host == "" || len(parsed.Path) < 2 {
+		return nil, "", false, fmt.Errorf("invalid selector config URI %q, expected s3://bucket/key", uri)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(parsed.Host),
+		Key:    aws.String(strings.TrimPrefix(parsed.Path, "/")),
+	}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+
+	resp, err := client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotModified {
+			return nil, etag, true, nil
+		}
+		return nil, "", false, fmt.Errorf("failed to get selector config from %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to read selector config from %s: %w", uri, err)
+	}
+	return body, aws.StringValue(resp.ETag), false, nil
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRefreshKeepsConfigMissingAProfile(t *testing.T) {
+	ctx := context.Background()
+	if err := NewProcessor(Config{}, Dependencies{}).LoadSelectorConfig(ctx); err != nil {
+		t.Fatal(err)
+	}
+	loaded := activeSelectors.current()
+	t.Cleanup(func() {
+		activeSelectors.mu.Lock()
+		activeSelectors.config, activeSelectors.source, activeSelectors.etag = loaded, "embedded", ""
+		activeSelectors.mu.Unlock()
+	})
+
+	// A valid config that no longer has the mobile device
+	var config map[string]any
+	if err := json.Unmarshal(embeddedSelectorConfig, &config); err != nil {
+		t.Fatal(err)
+	}
+	delete(config["devices"].(map[string]any), DeviceMobile)
+	config["version"] = "without-mobile"
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "selectors.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseSelectorConfig(data); err != nil {
+		t.Fatalf("config without mobile does not parse: %v", err)
+	}
+
+	cfg := Config{Selectors: SelectorSource{File: path, RefreshInterval: time.Nanosecond}}
+	NewProcessor(cfg, Dependencies{}).RefreshSelectorConfig(ctx)
+
+	if current := activeSelectors.current(); current != loaded {
+		t.Errorf("got config %s after the refresh, want %s kept", current.Version, loaded.Version)
+	}
+	if _, err := selectorsFor(MobileProfile); err != nil {
+		t.Errorf("mobile selectors lost: %v", err)
+	}
+}
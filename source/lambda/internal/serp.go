@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SerpSelectors locate the top-level sections of a result page and the
+// organic web results. All of them are optional.
+type SerpSelectors struct {
+	// Section matches the top-level sections, in page order
+	Section string `json:"section,omitempty"`
+
+	// Heading matches a section's title, relative to the section
+	Heading string `json:"heading,omitempty"`
+
+	// Types classify a section, checked in order; a type applies when the
+	// section itself or one of its descendants matches
+	Types []SectionType `json:"types,omitempty"`
+
+	Organic OrganicSelectors `json:"organic,omitempty"`
+}
+
+// SectionType maps a section selector to a section type (SectionShopping, ...)
+type SectionType struct {
+	Type  string `json:"type"`
+	Match string `json:"match"`
+}
+
+// OrganicSelectors extract organic web results. Result is matched against the
+// whole page; the others are relative to a result.
+type OrganicSelectors struct {
+	Result string `json:"result,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Link   string `json:"link,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Desc   string `json:"desc,omitempty"`
+}
+
+// extractPage extracts the ads, sections and organic results of a page.
+// Blocked and drifted pages fail as in extractResults.
+func extractPage(doc *goquery.Document, keyword, device string, sel SelectorSet) (PageResult, error) {
+	ads, err := extractResults(doc, keyword, device, sel)
+	if err != nil {
+		return PageResult{}, err
+	}
+	return PageResult{
+		Ads:      ads,
+		Organic:  extractOrganic(doc, keyword, device, sel.Sections.Organic),
+		Sections: extractSections(doc, keyword, device, sel.Sections),
+	}, nil
+}
+
+// extractSections lists the page's top-level sections in order
+func extractSections(doc *goquery.Document, keyword, device string, sel SerpSelectors) []SerpSection {
+	if sel.Section == "" {
+		return nil
+	}
+
+	var sections []SerpSection
+	doc.Find(sel.Section).Each(func(_ int, s *goquery.Selection) {
+		sections = append(sections, SerpSection{
+			Query:    keyword,
+			Device:   device,
+			Position: len(sections) + 1,
+			Type:     sectionType(s, sel.Types),
+			Heading:  firstText(s, sel.Heading),
+		})
+	})
+	return sections
+}
+
+// sectionType returns the first type whose selector matches the section or its content
+func sectionType(section *goquery.Selection, types []SectionType) string {
+	for _, t := range types {
+		if section.Is(t.Match) || section.Find(t.Match).Length() > 0 {
+			return t.Type
+		}
+	}
+	return SectionOther
+}
+
+// extractOrganic extracts the organic web results in page order
+func extractOrganic(doc *goquery.Document, keyword, device string, sel OrganicSelectors) []OrganicResult {
+	if sel.Result == "" {
+		return nil
+	}
+
+	var results []OrganicResult
+	doc.Find(sel.Result).Each(func(i int, s *goquery.Selection) {
+		result := OrganicResult{
+			Query:       keyword,
+			Device:      device,
+			Rank:        i + 1,
+			Title:       firstText(s, sel.Title),
+			DisplayURL:  sanitizeURL(firstText(s, sel.URL)),
+			Description: firstText(s, sel.Desc),
+		}
+		if sel.Link != "" {
+			href, _ := s.Find(sel.Link).First().Attr("href")
+			result.URL = landingURL(href)
+		}
+		results = append(results, result)
+	})
+	return results
+}
+
+// stamp sets the crawl metadata shared by every record of the page
+func (p *PageResult) stamp(meta CrawlMeta) {
+	stampAds(p.Ads, meta)
+	for i := range p.Organic {
+		p.Organic[i].CrawlMeta = meta
+	}
+	for i := range p.Sections {
+		p.Sections[i].CrawlMeta = meta
+	}
+}
+
+// stampAds sets the crawl metadata of ads
+func stampAds(ads []SearchResult, meta CrawlMeta) {
+	for i := range ads {
+		ads[i].CrawlMeta = meta
+	}
+}
+
+// validate checks that every configured selector compiles
+func (s SerpSelectors) validate() error {
+	fields := []struct{ name, value string }{
+		{"section", s.Section},
+		{"section heading", s.Heading},
+		{"organic result", s.Organic.Result},
+		{"organic title", s.Organic.Title},
+		{"organic link", s.Organic.Link},
+		{"organic url", s.Organic.URL},
+		{"organic desc", s.Organic.Desc},
+	}
+	for _, t := range s.Types {
+		if strings.TrimSpace(t.Type) == "" || strings.TrimSpace(t.Match) == "" {
+			return fmt.Errorf("section type %q needs both a type and a match selector", t.Type)
+		}
+		fields = append(fields, struct{ name, value string }{"section type " + t.Type, t.Match})
+	}
+	return compileOptional(fields)
+}
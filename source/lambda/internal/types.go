@@ -1,26 +1,152 @@
 package internal
 
+import "time"
+
 // SearchRequest represents the input for a search crawling operation
 type SearchRequest struct {
 	Keyword string `json:"keyword"`
 	Device  string `json:"device,omitempty"`
+
+	// Retries is the number of attempts made on this request before it was
+	// requeued after a partial failure, so the attempt cap survives the new
+	// message's receive count
+	Retries int `json:"retries,omitempty"`
+}
+
+// Message is a queued search request, whether it was received by polling
+// SQS or delivered in an SQS event by an event source mapping
+type Message struct {
+	ID            string
+	Body          string
+	ReceiptHandle string
+
+	// ReceiveCount is SQS's ApproximateReceiveCount, at least 1
+	ReceiveCount int
+}
+
+// CrawlMeta describes the page fetch a record was extracted from, so rows can
+// be deduplicated and correlated with the crawler's logs
+type CrawlMeta struct {
+	// CrawledAt is when the page was received
+	CrawledAt time.Time `json:"crawled_at"`
+
+	// RequestID identifies one crawl of a keyword on a device; every page
+	// and record of the crawl shares it
+	RequestID string `json:"request_id"`
+
+	UserAgent  string `json:"user_agent"`
+	HTTPStatus int    `json:"http_status"`
+
+	// LatencyMs is the time from sending the request to reading the whole
+	// body, for the attempt that succeeded
+	LatencyMs int64 `json:"latency_ms"`
+
+	// LambdaRequestID is the AWS request ID of the invocation; empty outside Lambda
+	LambdaRequestID string `json:"lambda_request_id,omitempty"`
 }
 
 // SearchResult represents a single search result from Naver
 type SearchResult struct {
-	Query       string `json:"query"`
-	Device      string `json:"device"`
+	Query  string `json:"query"`
+	Device string `json:"device"`
+	CrawlMeta
 	Rank        int    `json:"rank"`
 	SiteName    string `json:"site_name"`
 	DisplayURL  string `json:"display_url"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
+
+	// LandingURL is the click-through URL, with Naver's ad redirect decoded
+	LandingURL string `json:"landing_url"`
+
+	// AdBlock is the ad block the item appeared in (AdBlockPowerLinkTop, ...)
+	AdBlock string `json:"ad_block"`
+
+	Extensions AdExtensions `json:"extensions"`
+
+	// Page is the page the ad was found on: 1 for the result page, 2 for the
+	// first ad list page, ... Rank counts across pages.
+	Page int `json:"page"`
+}
+
+// AdExtensions are the optional extras an ad may carry
+type AdExtensions struct {
+	Sitelinks  []Sitelink `json:"sitelinks,omitempty"`
+	Phone      string     `json:"phone,omitempty"`
+	Price      string     `json:"price,omitempty"`
+	Promotion  string     `json:"promotion,omitempty"`
+	Thumbnails []string   `json:"thumbnails,omitempty"`
+}
+
+// Sitelink is an additional link shown under an ad
+type Sitelink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// OrganicResult is a non-ad web result
+type OrganicResult struct {
+	Query  string `json:"query"`
+	Device string `json:"device"`
+	CrawlMeta
+	Rank        int    `json:"rank"`
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	DisplayURL  string `json:"display_url"`
+	Description string `json:"description"`
+}
+
+// SerpSection is one top-level section of a result page, in page order.
+// A crawl's sections share Query, Device and CrawlMeta with its ads.
+type SerpSection struct {
+	Query  string `json:"query"`
+	Device string `json:"device"`
+	CrawlMeta
+	Position int    `json:"position"`
+	Type     string `json:"type"`
+	Heading  string `json:"heading"`
 }
 
+// PageResult is everything extracted from one result page
+type PageResult struct {
+	Ads      []SearchResult  `json:"ads"`
+	Organic  []OrganicResult `json:"organic"`
+	Sections []SerpSection   `json:"sections"`
+
+	// CrawledAt is when the crawl's first page was received. It decides the
+	// partition hour of the results and of every archived page of the crawl.
+	CrawledAt time.Time `json:"-"`
+}
+
+// Section types; sections matching no configured type are SectionOther
+const (
+	SectionPowerLink   = "powerlink"
+	SectionBrandSearch = "brand_search"
+	SectionShopping    = "shopping"
+	SectionPlace       = "place"
+	SectionBlog        = "blog"
+	SectionNews        = "news"
+	SectionWeb         = "web"
+	SectionOther       = "other"
+)
+
+// Ad blocks a result can appear in
+const (
+	AdBlockPowerLinkTop    = "powerlink_top"
+	AdBlockPowerLinkBottom = "powerlink_bottom"
+	AdBlockBrandSearch     = "brand_search"
+
+	// AdBlockAdList marks ads found on the ad list pages (SearchResult.Page > 1)
+	AdBlockAdList = "ad_list"
+)
+
 // Device types for crawling
 const (
 	DeviceDesktop = "PC"
 	DeviceMobile  = "MO"
+
+	// DeviceAll crawls both desktop and mobile (same as an empty device)
+	DeviceAll = "ALL"
 )
 
 // Default values for empty fields
@@ -1,6 +1,8 @@
 package internal
 
-import "strings"
+import (
+	"strings"
+)
 
 // setDefaultValueIfEmpty returns the defaultValue if the input string is empty or whitespace-only
 func setDefaultValueIfEmpty(value, defaultValue string) string {
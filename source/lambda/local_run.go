@@ -0,0 +1,109 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"lambda/internal"
+)
+
+// presets are queries the fake server answers with a failure instead of a fixture
+var presets = map[string]internal.FakeBehavior{
+	"rate-limited": {Status: http.StatusTooManyRequests, RetryAfter: "1"},
+	"unavailable":  {Status: http.StatusServiceUnavailable, Times: 1, Fixture: "ads_present"},
+	"slow":         {Delay: 10 * time.Second},
+	"blocked":      {Fixture: "captcha"},
+}
+
+func main() {
+	var fixtures, keywords string
+	var serve bool
+	flag.StringVar(&fixtures, "fixtures", "internal/testdata/serp", "Directory with <device>/<query>.html fixtures")
+	flag.StringVar(&keywords, "keywords", "ads_present,multi_part_title,paginated,no_ads,unavailable,blocked", "Comma-separated keywords to crawl")
+	flag.BoolVar(&serve, "serve", false, "Only run the fake Naver server until interrupted")
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	fake := internal.NewFakeNaver(fixtures)
+	defer fake.Close()
+	for query, behavior := range presets {
+		fake.Set(query, behavior)
+	}
+
+	if serve {
+		fmt.Printf("Fake Naver listening on %s\n", fake.URL)
+		fmt.Printf("Run the crawler with %s=%s %s=%s\n",
+			internal.DesktopSearchBaseURLEnv, fake.URL, internal.MobileSearchBaseURLEnv, fake.URL)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt)
+		<-stop
+		return
+	}
+
+	cfg, err := internal.LoadConfig()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	cfg.DesktopSearchBaseURL = fake.URL
+	cfg.MobileSearchBaseURL = fake.URL
+	if err := internal.ApplySearchBaseURLs(cfg); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	internal.ApplyRateLimit(cfg)
+	internal.ApplyMetricsNamespace(cfg)
+
+	var bodies []string
+	for _, keyword := range strings.Split(keywords, ",") {
+		body, _ := json.Marshal(internal.SearchRequest{Keyword: strings.TrimSpace(keyword)})
+		bodies = append(bodies, string(body))
+	}
+
+	queue := internal.NewMemoryQueue(bodies...)
+	sink := &internal.MemorySink{}
+	processor := internal.NewProcessor(cfg, internal.Dependencies{Queue: queue, Sink: sink})
+
+	ctx := context.Background()
+	if err := processor.LoadSelectorConfig(ctx); err != nil {
+		log.Fatalf("❌ Invalid selector config: %v", err)
+	}
+
+	// Requeued devices come back as new messages, so drain until the queue is empty
+	for round := 1; round <= cfg.MaxReceiveCount; round++ {
+		messages, err := processor.ReceiveMessages(ctx)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if len(messages) == 0 {
+			break
+		}
+		var finished []internal.Message
+		for _, msg := range messages {
+			if processor.ProcessMessage(ctx, msg) {
+				finished = append(finished, msg)
+			}
+		}
+		// Upload every round so no finished message is still held when the
+		// rest expire back onto the queue
+		processor.DeleteMessages(ctx, processor.CommitMessages(ctx, finished))
+		processor.DeleteMessages(ctx, processor.FlushResults(ctx))
+		queue.ExpireInFlight()
+	}
+
+	jsonData, err := json.MarshalIndent(sink.Results(), "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to marshal JSON: %v", err)
+	}
+	fmt.Println(string(jsonData))
+	fmt.Printf("\nTotal results: %d, still queued: %d\n", len(sink.Results()), len(queue.Visible()))
+}
@@ -5,69 +5,55 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"sync"
 
 	"lambda/internal"
 
 	"github.com/aws/aws-lambda-go/lambda"
 )
 
+// handlerModeEnv selects the entry point: "sqs-event" for invocations from an
+// SQS event source mapping, anything else for the scheduled polling handler
+const handlerModeEnv = "HANDLER_MODE"
+
 func main() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(0)
-	lambda.Start(handler)
-}
 
-func processRound(roundNum int) (int, error) {
-	messages, err := internal.ReceiveMessages()
+	cfg, err := internal.LoadConfig()
 	if err != nil {
-		log.Printf("❌ Failed to receive messages: %v\n", err)
-		return 0, err
+		log.Fatalf("❌ %v", err)
 	}
 
-	if len(messages) == 0 {
-		return 0, nil
+	if err := internal.ApplySearchBaseURLs(cfg); err != nil {
+		log.Fatalf("❌ %v", err)
 	}
+	internal.ApplyRateLimit(cfg)
+	internal.ApplyMetricsNamespace(cfg)
 
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, 10)
-
-	log.Printf("🔄 Round %d: Processing %d keywords with 10 goroutines", roundNum, len(messages))
+	deps, err := internal.NewAWSDependencies(cfg)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	processor := internal.NewProcessor(cfg, deps)
 
-	for _, msg := range messages {
-		localMsg := msg
-		wg.Add(1)
-		sem <- struct{}{}
-		go internal.ProcessMessage(localMsg, &wg, sem)
+	if err := processor.LoadSelectorConfig(context.Background()); err != nil {
+		log.Fatalf("❌ Invalid selector config: %v", err)
 	}
 
-	wg.Wait()
-	log.Printf("✅ Round %d completed: %d keywords processed", roundNum, len(messages))
-	return len(messages), nil
+	if os.Getenv(handlerModeEnv) == "sqs-event" {
+		lambda.Start(processor.HandleSQSEvent)
+		return
+	}
+	lambda.Start(func(ctx context.Context) (string, error) {
+		return handler(ctx, processor)
+	})
 }
 
-func handler(_ context.Context) (string, error) {
-	// Registry Cache 테스트를 위한 수정
-	// 잘 적용되었나 확인해보기
-	const totalRounds = 5
-	totalProcessed := 0
-
-	log.Printf("🚀 Starting lambda execution: %d rounds, 10 keywords per round", totalRounds)
-
-	for round := 1; round <= totalRounds; round++ {
-		processed, err := processRound(round)
-		if err != nil {
-			return "", fmt.Errorf("error in round %d: %v", round, err)
-		}
-
-		if processed == 0 {
-			log.Printf("🏁 Round %d: No messages to process, stopping early", round)
-			break
-		}
-
-		totalProcessed += processed
-		log.Printf("📊 Round %d summary: %d keywords processed (Total: %d)", round, processed, totalProcessed)
+func handler(ctx context.Context, processor *internal.Processor) (string, error) {
+	stats, err := processor.Run(ctx)
+	if err != nil {
+		return "", fmt.Errorf("stopped after %d keywords: %w", stats.Received, err)
 	}
-
-	return fmt.Sprintf("Lambda completed. Total rounds: %d, Total keywords processed: %d", totalRounds, totalProcessed), nil
+	return fmt.Sprintf("Lambda completed. Total keywords received: %d, finished: %d, released: %d",
+		stats.Received, stats.Finished, stats.Released), nil
 }
@@ -0,0 +1,75 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"lambda/internal"
+)
+
+// hourLayout is the KST hour format of -from and -to, e.g. 2025081309
+const hourLayout = "2006010215"
+
+func main() {
+	var from, to string
+	var dryRun, force bool
+	flag.StringVar(&from, "from", "", "First hour to reprocess, YYYYMMDDHH in KST")
+	flag.StringVar(&to, "to", "", "Last hour to reprocess (inclusive), defaults to -from")
+	flag.BoolVar(&dryRun, "dry-run", false, "Extract and report without rewriting any output")
+	flag.BoolVar(&force, "force", false, "Replace an hour's outputs even when some of its pages fail extraction or its outputs hold unarchived crawls")
+	flag.Parse()
+
+	if from == "" {
+		fmt.Println("Usage: go run reprocess.go -from=YYYYMMDDHH [-to=YYYYMMDDHH] [-dry-run] [-force]")
+		fmt.Println("Example: go run reprocess.go -from=2025081300 -to=2025081323")
+		os.Exit(1)
+	}
+	if to == "" {
+		to = from
+	}
+
+	kst := time.FixedZone("KST", 9*60*60)
+	fromHour, err := time.ParseInLocation(hourLayout, from, kst)
+	if err != nil {
+		log.Fatalf("❌ Invalid -from: %v", err)
+	}
+	toHour, err := time.ParseInLocation(hourLayout, to, kst)
+	if err != nil {
+		log.Fatalf("❌ Invalid -to: %v", err)
+	}
+
+	cfg, err := internal.LoadConfig()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	deps, err := internal.NewAWSDependencies(cfg)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	// Extraction uses the same selector config as the crawler
+	ctx := context.Background()
+	if err := internal.NewProcessor(cfg, deps).LoadSelectorConfig(ctx); err != nil {
+		log.Fatalf("❌ Invalid selector config: %v", err)
+	}
+
+	reprocessor := internal.NewReprocessor(deps.S3, cfg.Bucket)
+	reprocessor.DryRun = dryRun
+	reprocessor.Force = force
+	if reprocessor.Encoder, err = internal.NewEncoder(cfg.ResultFormat); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	stats, err := reprocessor.Reprocess(ctx, fromHour, toHour)
+	fmt.Printf("Hours: %d, pages: %d (%d failed, %d blocked), results: %d, replaced objects: %d\n",
+		stats.Hours, stats.Pages, stats.Failed, stats.Blocked, stats.Results, stats.Replaced)
+	if err != nil {
+		log.Fatalf("❌ Reprocessing stopped: %v", err)
+	}
+}